@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// runCheckLedger implements the check-ledger subcommand. It exits with 1 when
+// drift was found and left unrepaired, so it can run from cron or CI.
+func runCheckLedger(ctx context.Context, logger *slog.Logger, databaseURL string, args []string) int {
+	flags := flag.NewFlagSet("check-ledger", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "reset drifted ratings to the replayed value")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	pool, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		logger.Error("connect database", "error", err)
+		return 2
+	}
+	defer pool.Close()
+
+	drifts, err := db.NewStore(pool).CheckLedger(ctx, *repair)
+	if err != nil {
+		logger.Error("check ledger", "error", err)
+		return 2
+	}
+	printDrifts(os.Stdout, drifts, *repair)
+	if len(drifts) > 0 && !*repair {
+		return 1
+	}
+	return 0
+}
+
+func printDrifts(w io.Writer, drifts []db.RatingDrift, repaired bool) {
+	if len(drifts) == 0 {
+		fmt.Fprintln(w, "ledger is consistent")
+		return
+	}
+	fmt.Fprintf(w, "%-8s %-30s %10s %10s %8s\n", "player", "name", "stored", "expected", "drift")
+	for _, d := range drifts {
+		fmt.Fprintf(w, "%-8d %-30s %10d %10d %+8d\n", d.PlayerID, d.FullName, d.Stored, d.Expected, d.Delta())
+	}
+	if repaired {
+		fmt.Fprintf(w, "repaired %d players\n", len(drifts))
+	} else {
+		fmt.Fprintf(w, "%d players drifted, run with --repair to fix\n", len(drifts))
+	}
+}
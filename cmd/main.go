@@ -14,6 +14,7 @@ import (
 	"rts_for_rating_on_larp/internal/admin"
 	"rts_for_rating_on_larp/internal/config"
 	"rts_for_rating_on_larp/internal/db"
+	"rts_for_rating_on_larp/internal/scheduler"
 	"rts_for_rating_on_larp/internal/telegram"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -28,12 +29,18 @@ func main() {
 		logger.Error("DATABASE_URL is required")
 		os.Exit(1)
 	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 && os.Args[1] == "check-ledger" {
+		code := runCheckLedger(ctx, logger, cfg.DatabaseURL, os.Args[2:])
+		stop()
+		os.Exit(code)
+	}
 	if cfg.TelegramToken == "" {
 		logger.Error("TELEGRAM_TOKEN is required")
 		os.Exit(1)
 	}
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
 
 	pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
 	if err != nil {
@@ -53,6 +60,7 @@ func main() {
 		logger.Error("ensure system config", "error", err)
 		os.Exit(1)
 	}
+	go scheduler.NewCycleScheduler(store, logger, cfg.CyclePollInterval).Run(ctx)
 
 	botAPI, err := tgbotapi.NewBotAPI(cfg.TelegramToken)
 	if err != nil {
@@ -91,8 +99,11 @@ func main() {
 		}
 	}
 
-	bot := telegram.New(botAPI, store, logger, cfg.BotLinkBase)
-	adminHandler, err := admin.New(store, cfg.AdminToken)
+	bot := telegram.New(botAPI, store, logger, cfg.BotLinkBase, cfg.CharacterClasses)
+	if err := bot.RegisterCommandMenu(ctx); err != nil {
+		logger.Error("register command menu", "error", err)
+	}
+	adminHandler, err := admin.New(store, cfg.AdminToken, bot)
 	if err != nil {
 		logger.Error("init admin handler", "error", err)
 		os.Exit(1)
@@ -105,6 +116,7 @@ func main() {
 	})
 	mux.Handle("/admin", adminHandler)
 	mux.Handle("/admin/action", adminHandler)
+	mux.Handle("/admin/formula/dry_run", adminHandler)
 	mux.Handle(cfg.WebhookPath, bot.WebhookHandler())
 
 	server := &http.Server{
@@ -1,9 +1,30 @@
+CREATE TABLE rating_formulas (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    version INTEGER NOT NULL CHECK (version > 0),
+    strategy VARCHAR(50) NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}'::jsonb,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(name, version)
+);
+
 CREATE TABLE system_config (
     id SERIAL PRIMARY KEY,
     rating_formula_a NUMERIC(8,4) NOT NULL,
     rating_formula_b NUMERIC(8,4) NOT NULL,
     default_cycle_duration_minutes INTEGER NOT NULL CHECK (default_cycle_duration_minutes >= 15),
     default_rating_timeout_minutes INTEGER NOT NULL CHECK (default_rating_timeout_minutes > 0),
+    repeat_penalty_step NUMERIC(3,2) NOT NULL DEFAULT 0 CHECK (repeat_penalty_step BETWEEN 0 AND 1),
+    repeat_penalty_min NUMERIC(3,2) NOT NULL DEFAULT 0.25 CHECK (repeat_penalty_min BETWEEN 0 AND 1),
+    repeat_penalty_window_minutes INTEGER NOT NULL DEFAULT 0 CHECK (repeat_penalty_window_minutes >= 0),
+    rating_formula_id INTEGER REFERENCES rating_formulas(id),
+    faction_forbid_own_likes BOOLEAN NOT NULL DEFAULT FALSE,
+    faction_cross_weight NUMERIC(4,2) NOT NULL DEFAULT 1 CHECK (faction_cross_weight >= 0),
+    leaderboard_hide_numbers BOOLEAN NOT NULL DEFAULT FALSE,
+    rater_visibility VARCHAR(20) NOT NULL DEFAULT 'admins' CHECK (rater_visibility IN ('anonymous', 'admins', 'after_cycle')),
+    version INTEGER NOT NULL DEFAULT 1,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
@@ -2,6 +2,7 @@ package admin
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
@@ -10,25 +11,57 @@ import (
 	"strings"
 
 	"rts_for_rating_on_larp/internal/db"
+	"rts_for_rating_on_larp/internal/formula"
 )
 
 type Handler struct {
 	store      *db.Store
 	adminToken string
+	notifier   Notifier
 	tpl        *template.Template
 }
 
+// webRole is the permission role of the web admin: the admin token grants
+// full access.
+const webRole = db.RoleSuperAdmin
+
+// adminTopSize is the length of the ranking on the admin page, which always
+// shows exact ratings.
+const adminTopSize = 20
+
+// Notifier delivers messages about changes made in the admin to players.
+type Notifier interface {
+	NotifyRatingAdjustment(player db.Player, adjustment db.RatingAdjustment) error
+	// UpdateCommandMenu updates the player's command menu for the new role.
+	UpdateCommandMenu(player db.Player) error
+}
+
 type viewData struct {
-	Message string
-	Error   string
+	Message     string
+	Error       string
+	CycleNumber int
+	Boundaries  []db.LevelBoundary
+	Rating      *db.RatingRecord
+	Formulas    []db.RatingFormula
+	Current     *db.RatingFormula
+	Next        *db.RatingFormula
+	Strategies  []formula.Strategy
+	// AdminActions is set after a search in the action history.
+	AdminActions    []db.AdminAction
+	Drifts          []db.RatingDrift
+	Factions        []db.FactionStanding
+	FactionRules    db.FactionRules
+	Top             []db.LeaderboardEntry
+	HideNumbers     bool
+	RaterVisibility string
 }
 
-func New(store *db.Store, adminToken string) (*Handler, error) {
+func New(store *db.Store, adminToken string, notifier Notifier) (*Handler, error) {
 	tpl, err := template.New("admin").Parse(adminTemplate)
 	if err != nil {
 		return nil, err
 	}
-	return &Handler{store: store, adminToken: adminToken, tpl: tpl}, nil
+	return &Handler{store: store, adminToken: adminToken, notifier: notifier, tpl: tpl}, nil
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -40,9 +73,11 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	switch {
 	case r.Method == http.MethodGet && r.URL.Path == "/admin":
-		h.render(w, viewData{})
+		h.render(w, r, viewData{})
 	case r.Method == http.MethodPost && r.URL.Path == "/admin/action":
 		h.handleAction(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/admin/formula/dry_run":
+		h.handleFormulaDryRun(w, r)
 	default:
 		w.WriteHeader(http.StatusNotFound)
 	}
@@ -60,14 +95,49 @@ func (h *Handler) authorize(r *http.Request) bool {
 
 func (h *Handler) handleAction(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		h.render(w, viewData{Error: "Некорректные данные."})
+		h.render(w, r, viewData{Error: "Некорректные данные."})
 		return
 	}
 	ctx := r.Context()
+
+	// Each action runs in one transaction with its audit entry, so a change is
+	// never applied without being recorded.
+	var res actionResult
+	err := h.store.Audit(ctx, db.AdminAction{}, func(tx *db.Store, audit *db.AdminAction) error {
+		var err error
+		res, err = h.applyAction(ctx, tx, r)
+		*audit = res.audit
+		audit.Source = db.AdminSourceWeb
+		return err
+	})
+	if err != nil {
+		h.render(w, r, viewData{Error: err.Error()})
+		return
+	}
+	if res.notify != nil {
+		res.message += res.notify()
+	}
+	res.data.Message = res.message
+	h.render(w, r, res.data)
+}
+
+// actionResult is the outcome of an action of the admin page.
+type actionResult struct {
+	message string
+	data    viewData
+	// audit is filled by every action that changes state; actions that record
+	// their own audit entry leave it empty.
+	audit db.AdminAction
+	// notify tells players about the change once it is committed and returns
+	// a note for the message when that fails.
+	notify func() string
+}
+
+func (h *Handler) applyAction(ctx context.Context, store *db.Store, r *http.Request) (actionResult, error) {
 	action := r.FormValue("action")
 
 	var err error
-	var message string
+	var res actionResult
 	switch action {
 	case "set_cycle_duration":
 		minutes, convErr := strconv.Atoi(strings.TrimSpace(r.FormValue("minutes")))
@@ -75,16 +145,45 @@ func (h *Handler) handleAction(w http.ResponseWriter, r *http.Request) {
 			err = errors.New("Длительность должна быть >= 15")
 			break
 		}
-		err = h.store.UpdateCycleDuration(ctx, minutes)
-		message = fmt.Sprintf("Длительность цикла обновлена: %d мин.", minutes)
+		cfg, cfgErr := store.GetSystemConfig(ctx)
+		if cfgErr != nil {
+			err = cfgErr
+			break
+		}
+		err = store.UpdateCycleDuration(ctx, minutes)
+		res.audit = settingsAction("cycle_duration_minutes", cfg.DefaultCycleDuration, minutes)
+		res.message = fmt.Sprintf("Длительность цикла обновлена: %d мин.", minutes)
 	case "set_rating_timeout":
 		minutes, convErr := strconv.Atoi(strings.TrimSpace(r.FormValue("minutes")))
 		if convErr != nil || minutes <= 0 {
 			err = errors.New("Таймаут должен быть > 0")
 			break
 		}
-		err = h.store.UpdateRatingTimeout(ctx, minutes)
-		message = fmt.Sprintf("Таймаут оценок обновлен: %d мин.", minutes)
+		cfg, cfgErr := store.GetSystemConfig(ctx)
+		if cfgErr != nil {
+			err = cfgErr
+			break
+		}
+		err = store.UpdateRatingTimeout(ctx, minutes)
+		res.audit = settingsAction("rating_timeout_minutes", cfg.DefaultRatingTimeout, minutes)
+		res.message = fmt.Sprintf("Таймаут оценок обновлен: %d мин.", minutes)
+	case "set_repeat_penalty":
+		step, stepErr := strconv.ParseFloat(strings.TrimSpace(r.FormValue("step")), 64)
+		minimum, minErr := strconv.ParseFloat(strings.TrimSpace(r.FormValue("min")), 64)
+		window, windowErr := strconv.Atoi(strings.TrimSpace(r.FormValue("window_minutes")))
+		if stepErr != nil || minErr != nil || windowErr != nil || step < 0 || step > 1 || minimum < 0 || minimum > 1 || window < 0 {
+			err = errors.New("Шаг и минимум должны быть от 0 до 1, окно — не меньше 0")
+			break
+		}
+		cfg, cfgErr := store.GetSystemConfig(ctx)
+		if cfgErr != nil {
+			err = cfgErr
+			break
+		}
+		rule := db.PenaltyRule{Step: step, Min: minimum, WindowMinutes: window}
+		err = store.UpdateRepeatPenalty(ctx, rule)
+		res.audit = settingsAction("repeat_penalty", cfg.RepeatPenalty, rule)
+		res.message = "Штраф за повторные оценки обновлен."
 	case "set_rating_limit":
 		level, levelErr := strconv.Atoi(strings.TrimSpace(r.FormValue("level")))
 		limit, limitErr := strconv.Atoi(strings.TrimSpace(r.FormValue("limit")))
@@ -92,8 +191,46 @@ func (h *Handler) handleAction(w http.ResponseWriter, r *http.Request) {
 			err = errors.New("Некорректные параметры уровня или лимита")
 			break
 		}
-		err = h.store.UpsertRatingLimit(ctx, level, limit)
-		message = fmt.Sprintf("Лимит для уровня %d обновлен: %d", level, limit)
+		if r.FormValue("scope") != "current" {
+			previous, listErr := store.ListSystemRatingLimits(ctx)
+			if listErr != nil {
+				err = listErr
+				break
+			}
+			err = store.UpsertRatingLimit(ctx, level, limit)
+			res.audit = db.AdminAction{ActionType: db.ActionSetRatingLimits, Details: map[string]any{
+				"scope": "next",
+				"level": level,
+				"old":   ratingLimitValue(previous, level),
+				"new":   limit,
+			}}
+			res.message = fmt.Sprintf("Лимит для уровня %d со следующего цикла: %d", level, limit)
+			break
+		}
+		cfg, cfgErr := store.GetSystemConfig(ctx)
+		if cfgErr != nil {
+			err = cfgErr
+			break
+		}
+		cycle, cycleErr := store.EnsureActiveCycle(ctx, cfg)
+		if cycleErr != nil {
+			err = cycleErr
+			break
+		}
+		previous, listErr := store.ListCycleRatingLimits(ctx, cycle.ID)
+		if listErr != nil {
+			err = listErr
+			break
+		}
+		err = store.UpsertCycleRatingLimit(ctx, cycle.ID, level, limit)
+		res.audit = db.AdminAction{ActionType: db.ActionSetRatingLimits, Details: map[string]any{
+			"scope":        "current",
+			"cycle_number": cycle.CycleNumber,
+			"level":        level,
+			"old":          ratingLimitValue(previous, level),
+			"new":          limit,
+		}}
+		res.message = fmt.Sprintf("Лимит для уровня %d в текущем цикле: %d", level, limit)
 	case "set_level_boundary":
 		level, levelErr := strconv.Atoi(strings.TrimSpace(r.FormValue("level")))
 		minRating, minErr := strconv.Atoi(strings.TrimSpace(r.FormValue("min_rating")))
@@ -102,20 +239,263 @@ func (h *Handler) handleAction(w http.ResponseWriter, r *http.Request) {
 			err = errors.New("Некорректные границы уровня")
 			break
 		}
-		cfg, cfgErr := h.store.GetSystemConfig(ctx)
+		cfg, cfgErr := store.GetSystemConfig(ctx)
 		if cfgErr != nil {
 			err = cfgErr
 			break
 		}
-		cycle, cycleErr := h.store.EnsureActiveCycle(ctx, cfg)
+		cycle, cycleErr := store.EnsureActiveCycle(ctx, cfg)
 		if cycleErr != nil {
 			err = cycleErr
 			break
 		}
-		err = h.store.SetLevelBoundary(ctx, cycle.ID, level, minRating, maxRating)
-		message = fmt.Sprintf("Границы уровня %d обновлены: %d-%d", level, minRating, maxRating)
+		previous, listErr := store.ListLevelBoundaries(ctx, cycle.ID)
+		if listErr != nil {
+			err = listErr
+			break
+		}
+		err = store.SetLevelBoundary(ctx, cycle.ID, level, minRating, maxRating)
+		res.audit = db.AdminAction{ActionType: db.ActionSetLevelBoundaries, Details: map[string]any{
+			"cycle_number": cycle.CycleNumber,
+			"level":        level,
+			"old":          boundaryValues(previous)[strconv.Itoa(level)],
+			"new":          [2]int{minRating, maxRating},
+		}}
+		res.message = fmt.Sprintf("Границы уровня %d обновлены: %d-%d", level, minRating, maxRating)
+	case "set_level_distribution":
+		shares := make([]int, 0, db.MaxLevel)
+		for level := 1; level <= db.MaxLevel; level++ {
+			value := strings.TrimSpace(r.FormValue(fmt.Sprintf("share_%d", level)))
+			if value == "" {
+				break
+			}
+			share, convErr := strconv.Atoi(value)
+			if convErr != nil {
+				shares = nil
+				break
+			}
+			shares = append(shares, share)
+		}
+		if db.ValidateLevelShares(shares) != nil {
+			err = errors.New("Доли должны быть положительными и в сумме давать 100")
+			break
+		}
+		cfg, cfgErr := store.GetSystemConfig(ctx)
+		if cfgErr != nil {
+			err = cfgErr
+			break
+		}
+		cycle, cycleErr := store.EnsureActiveCycle(ctx, cfg)
+		if cycleErr != nil {
+			err = cycleErr
+			break
+		}
+		previous, listErr := store.ListLevelBoundaries(ctx, cycle.ID)
+		if listErr != nil {
+			err = listErr
+			break
+		}
+		boundaries, applyErr := store.ApplyLevelDistribution(ctx, cycle.ID, shares)
+		if errors.Is(applyErr, db.ErrNoPlayers) {
+			err = errors.New("Нет игроков для распределения")
+			break
+		}
+		err = applyErr
+		res.audit = db.AdminAction{ActionType: db.ActionSetLevelBoundaries, Details: map[string]any{
+			"cycle_number": cycle.CycleNumber,
+			"shares":       shares,
+			"old":          boundaryValues(previous),
+			"new":          boundaryValues(boundaries),
+		}}
+		res.message = "Границы рассчитаны по распределению."
 	case "apply_level_recalc":
-		message, err = h.applyRecalc(ctx)
+		res.message, res.audit, err = h.applyRecalc(ctx, store)
+	case "refund_ratings":
+		telegramID, convErr := strconv.ParseInt(strings.TrimSpace(r.FormValue("telegram_id")), 10, 64)
+		count, countErr := strconv.Atoi(strings.TrimSpace(r.FormValue("count")))
+		if convErr != nil || countErr != nil || count <= 0 {
+			err = errors.New("Некорректные данные возврата")
+			break
+		}
+		player, playerErr := store.GetPlayerByTelegramID(ctx, telegramID)
+		if playerErr != nil {
+			err = errors.New("Игрок не найден")
+			break
+		}
+		before := player.RatingsAvailable
+		player, err = store.RefundRatings(ctx, player.ID, count)
+		res.audit = db.AdminAction{ActionType: db.ActionRefundRatings, TargetPlayerID: &player.ID, Details: map[string]any{
+			"count": count,
+			"old":   before,
+			"new":   player.RatingsAvailable,
+		}}
+		if err == nil && player.RatingsAvailable == nil {
+			res.message = fmt.Sprintf("У игрока %s нет лимита оценок.", player.FullName)
+			break
+		}
+		if err == nil {
+			res.message = fmt.Sprintf("Игроку %s возвращено оценок: %d. Доступно: %d.", player.FullName, count, *player.RatingsAvailable)
+		}
+	case "adjust_rating":
+		telegramID, convErr := strconv.ParseInt(strings.TrimSpace(r.FormValue("telegram_id")), 10, 64)
+		delta, deltaErr := strconv.Atoi(strings.TrimSpace(r.FormValue("delta")))
+		reason := strings.TrimSpace(r.FormValue("reason"))
+		if convErr != nil || deltaErr != nil || delta == 0 || reason == "" {
+			err = errors.New("Укажите игрока, ненулевое изменение и причину")
+			break
+		}
+		player, playerErr := store.GetPlayerByTelegramID(ctx, telegramID)
+		if playerErr != nil {
+			err = errors.New("Игрок не найден")
+			break
+		}
+		adjustment, adjusted, adjustErr := store.AdjustRating(ctx, db.RatingAdjustment{
+			PlayerID: player.ID,
+			Amount:   delta,
+			Reason:   reason,
+		}, db.AdminSourceWeb)
+		if adjustErr != nil {
+			err = adjustErr
+			break
+		}
+		res.message = fmt.Sprintf("Рейтинг игрока %s изменен на %+d: %d.", adjusted.FullName, delta, adjusted.Rating)
+		if h.notifier != nil {
+			res.notify = func() string {
+				if notifyErr := h.notifier.NotifyRatingAdjustment(adjusted, adjustment); notifyErr != nil {
+					return " Уведомление не доставлено."
+				}
+				return ""
+			}
+		}
+	case "rating_details":
+		ratingID, convErr := strconv.ParseInt(strings.TrimSpace(r.FormValue("rating_id")), 10, 64)
+		if convErr != nil {
+			err = errors.New("Некорректный номер оценки")
+			break
+		}
+		record, getErr := store.GetRating(ctx, ratingID, db.Viewer{Role: webRole})
+		if getErr != nil {
+			err = errors.New("Оценка не найдена")
+			break
+		}
+		res.data.Rating = &record
+		res.message = fmt.Sprintf("Оценка №%d", record.ID)
+	case "check_ledger":
+		repair := r.FormValue("repair") == "on"
+		drifts, checkErr := store.CheckLedger(ctx, repair)
+		if checkErr != nil {
+			err = checkErr
+			break
+		}
+		res.data.Drifts = drifts
+		switch {
+		case len(drifts) == 0:
+			res.message = "Рейтинги совпадают с журналом оценок и переводов."
+		case repair:
+			res.message = fmt.Sprintf("Исправлены рейтинги игроков: %d.", len(drifts))
+			res.audit = db.AdminAction{ActionType: db.ActionAdjustRating, Details: map[string]any{
+				"event":   "ledger_repair",
+				"players": len(drifts),
+			}}
+		default:
+			res.message = fmt.Sprintf("Расхождения у игроков: %d.", len(drifts))
+		}
+	case "admin_log":
+		filter := db.AdminActionFilter{ActionType: strings.TrimSpace(r.FormValue("action_type")), Limit: 100}
+		if value := strings.TrimSpace(r.FormValue("telegram_id")); value != "" {
+			telegramID, convErr := strconv.ParseInt(value, 10, 64)
+			if convErr != nil {
+				err = errors.New("Некорректный telegram_id")
+				break
+			}
+			player, playerErr := store.GetPlayerByTelegramID(ctx, telegramID)
+			if playerErr != nil {
+				err = errors.New("Игрок не найден")
+				break
+			}
+			filter.PlayerID = player.ID
+		}
+		actions, listErr := store.ListAdminActions(ctx, filter)
+		if listErr != nil {
+			err = listErr
+			break
+		}
+		res.data.AdminActions = actions
+		res.message = fmt.Sprintf("Найдено действий: %d", len(actions))
+	case "save_formula":
+		name := strings.TrimSpace(r.FormValue("name"))
+		params, parseErr := formula.ParseParams(strings.Fields(r.FormValue("params")))
+		if name == "" || strings.Contains(name, "@") || parseErr != nil {
+			err = errors.New("Укажите имя без @ и параметры в виде имя=число")
+			break
+		}
+		candidate := formula.Formula{Strategy: strings.TrimSpace(r.FormValue("strategy")), Params: params}
+		if validateErr := candidate.Validate(); validateErr != nil {
+			err = fmt.Errorf("Формула некорректна: %v", validateErr)
+			break
+		}
+		saved, saveErr := store.SaveFormula(ctx, name, candidate.Strategy, candidate.Params, strings.TrimSpace(r.FormValue("description")))
+		if saveErr != nil {
+			err = saveErr
+			break
+		}
+		res.audit = db.AdminAction{ActionType: db.ActionChangeRatingFormula, Details: map[string]any{
+			"operation": "save",
+			"new":       formatFormula(saved),
+		}}
+		res.message = fmt.Sprintf("Формула %s сохранена, версия %d.", saved.Name, saved.Version)
+	case "use_formula":
+		version, convErr := strconv.Atoi(strings.TrimSpace(r.FormValue("version")))
+		if strings.TrimSpace(r.FormValue("version")) == "" {
+			version, convErr = 0, nil
+		}
+		if convErr != nil || version < 0 {
+			err = errors.New("Некорректная версия формулы")
+			break
+		}
+		selected, getErr := store.GetFormula(ctx, strings.TrimSpace(r.FormValue("name")), version)
+		if getErr != nil {
+			err = errors.New("Формула не найдена")
+			break
+		}
+		if r.FormValue("scope") != "current" {
+			previous, getErr := store.GetNextCycleFormula(ctx)
+			if getErr != nil {
+				err = getErr
+				break
+			}
+			err = store.SetNextCycleFormula(ctx, selected.ID)
+			res.audit = db.AdminAction{ActionType: db.ActionChangeRatingFormula, Details: map[string]any{
+				"scope": "next",
+				"old":   formatFormula(previous),
+				"new":   formatFormula(selected),
+			}}
+			res.message = fmt.Sprintf("Со следующего цикла: %s v%d", selected.Name, selected.Version)
+			break
+		}
+		cfg, cfgErr := store.GetSystemConfig(ctx)
+		if cfgErr != nil {
+			err = cfgErr
+			break
+		}
+		cycle, cycleErr := store.EnsureActiveCycle(ctx, cfg)
+		if cycleErr != nil {
+			err = cycleErr
+			break
+		}
+		previous, getErr := store.GetCycleFormula(ctx, cycle.ID)
+		if getErr != nil {
+			err = getErr
+			break
+		}
+		err = store.SetCycleFormula(ctx, cycle.ID, selected.ID)
+		res.audit = db.AdminAction{ActionType: db.ActionChangeRatingFormula, Details: map[string]any{
+			"scope":        "current",
+			"cycle_number": cycle.CycleNumber,
+			"old":          formatFormula(previous),
+			"new":          formatFormula(selected),
+		}}
+		res.message = fmt.Sprintf("В текущем цикле: %s v%d", selected.Name, selected.Version)
 	case "add_player":
 		telegramID, convErr := strconv.ParseInt(strings.TrimSpace(r.FormValue("telegram_id")), 10, 64)
 		fullName := strings.TrimSpace(r.FormValue("full_name"))
@@ -123,61 +503,339 @@ func (h *Handler) handleAction(w http.ResponseWriter, r *http.Request) {
 			err = errors.New("Некорректные данные игрока")
 			break
 		}
-		player, createErr := h.store.CreatePlayer(ctx, telegramID, "", fullName)
+		player, createErr := store.CreatePlayer(ctx, telegramID, "", fullName)
+		if createErr != nil {
+			err = createErr
+			break
+		}
+		_, _ = store.CreatePlayerLink(ctx, player.ID)
+		res.audit = db.AdminAction{ActionType: db.ActionCreatePlayer, TargetPlayerID: &player.ID, Details: map[string]any{
+			"telegram_id": telegramID,
+			"full_name":   fullName,
+		}}
+		res.message = fmt.Sprintf("Игрок создан: %s", fullName)
+	case "create_admin", "set_role":
+		telegramID, convErr := strconv.ParseInt(strings.TrimSpace(r.FormValue("telegram_id")), 10, 64)
+		if convErr != nil {
+			err = errors.New("Некорректный telegram_id")
+			break
+		}
+		role, actionType := db.RoleAdmin, db.ActionCreateAdmin
+		if action == "set_role" {
+			role, actionType = r.FormValue("role"), db.ActionChangePlayerRole
+		}
+		oldRole, player, changeErr := store.ChangePlayerRole(ctx, db.RoleChange{
+			ActorRole:        webRole,
+			TargetTelegramID: telegramID,
+			NewRole:          role,
+			Source:           db.AdminSourceWeb,
+			ActionType:       actionType,
+			RaiseOnly:        action == "create_admin",
+		})
+		switch {
+		case errors.Is(changeErr, db.ErrPlayerNotFound):
+			err = errors.New("Игрок не найден")
+		case errors.Is(changeErr, db.ErrInvalidRole):
+			err = errors.New("Неизвестная роль")
+		case errors.Is(changeErr, db.ErrLastSuperAdmin):
+			err = errors.New("Нельзя снять роль с последнего супер-администратора")
+		case errors.Is(changeErr, db.ErrRoleNotRaised):
+			err = errors.New("Игрок уже администратор или выше")
+		case changeErr != nil:
+			err = changeErr
+		default:
+			res.message = fmt.Sprintf("Роль игрока %s: %s → %s.", player.FullName, oldRole, player.Role)
+			if h.notifier != nil {
+				res.notify = func() string {
+					if notifyErr := h.notifier.UpdateCommandMenu(player); notifyErr != nil {
+						return " Меню команд не обновлено."
+					}
+					return ""
+				}
+			}
+		}
+	case "set_rater_visibility":
+		policy := r.FormValue("policy")
+		cfg, cfgErr := store.GetSystemConfig(ctx)
+		if cfgErr != nil {
+			err = cfgErr
+			break
+		}
+		if updateErr := store.UpdateRaterVisibility(ctx, policy); updateErr != nil {
+			err = updateErr
+			if errors.Is(updateErr, db.ErrInvalidRaterVisibility) {
+				err = errors.New("Неизвестная политика")
+			}
+			break
+		}
+		res.audit = settingsAction("rater_visibility", cfg.RaterVisibility, policy)
+		res.message = "Политика анонимности обновлена."
+	case "set_leaderboard_numbers":
+		cfg, cfgErr := store.GetSystemConfig(ctx)
+		if cfgErr != nil {
+			err = cfgErr
+			break
+		}
+		hide := r.FormValue("hide_numbers") != ""
+		err = store.UpdateLeaderboardVisibility(ctx, hide)
+		res.audit = settingsAction("leaderboard_hide_numbers", cfg.HideLeaderboardNumbers, hide)
+		res.message = "Игроки видят в рейтингах точные числа."
+		if hide {
+			res.message = "Игроки видят в рейтингах только места и уровни."
+		}
+	case "create_faction":
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			err = errors.New("Укажите название фракции")
+			break
+		}
+		faction, createErr := store.CreateFaction(ctx, name, strings.TrimSpace(r.FormValue("description")))
+		if errors.Is(createErr, db.ErrFactionExists) {
+			err = errors.New("Такая фракция уже есть")
+			break
+		}
 		if createErr != nil {
 			err = createErr
 			break
 		}
-		_, _ = h.store.CreatePlayerLink(ctx, player.ID)
-		message = fmt.Sprintf("Игрок создан: %s", fullName)
-	case "create_admin":
+		res.audit = db.AdminAction{ActionType: db.ActionCreateFaction, Details: map[string]any{
+			"faction_id":  faction.ID,
+			"name":        faction.Name,
+			"description": faction.Description,
+		}}
+		res.message = fmt.Sprintf("Фракция создана: %s", faction.Name)
+	case "set_faction":
 		telegramID, convErr := strconv.ParseInt(strings.TrimSpace(r.FormValue("telegram_id")), 10, 64)
 		if convErr != nil {
 			err = errors.New("Некорректный telegram_id")
 			break
 		}
-		err = h.store.SetPlayerRole(ctx, telegramID, "admin")
-		message = "Администратор назначен."
+		player, getErr := store.GetPlayerByTelegramID(ctx, telegramID)
+		if getErr != nil {
+			err = errors.New("Игрок не найден")
+			break
+		}
+		var factionID *int
+		newName := ""
+		if name := strings.TrimSpace(r.FormValue("faction")); name != "" {
+			faction, findErr := store.GetFactionByName(ctx, name)
+			if findErr != nil {
+				err = errors.New("Фракция не найдена")
+				break
+			}
+			factionID, newName = &faction.ID, faction.Name
+		}
+		oldName := ""
+		if player.FactionID != nil {
+			if old, getErr := store.GetFaction(ctx, *player.FactionID); getErr == nil {
+				oldName = old.Name
+			}
+		}
+		err = store.SetPlayerFaction(ctx, player.ID, factionID)
+		res.audit = db.AdminAction{ActionType: db.ActionSetPlayerFaction, TargetPlayerID: &player.ID, Details: map[string]any{
+			"old": oldName,
+			"new": newName,
+		}}
+		res.message = fmt.Sprintf("Фракция игрока %s: %s", player.FullName, newName)
+		if newName == "" {
+			res.message = fmt.Sprintf("Игрок %s больше не состоит во фракции.", player.FullName)
+		}
+	case "set_faction_rules":
+		weight, convErr := strconv.ParseFloat(strings.TrimSpace(r.FormValue("cross_weight")), 64)
+		if convErr != nil || weight < 0 || weight >= 100 {
+			err = errors.New("Вес должен быть от 0 до 99.99")
+			break
+		}
+		cfg, cfgErr := store.GetSystemConfig(ctx)
+		if cfgErr != nil {
+			err = cfgErr
+			break
+		}
+		rules := db.FactionRules{ForbidOwnLikes: r.FormValue("forbid_own_likes") != "", CrossWeight: weight}
+		err = store.UpdateFactionRules(ctx, rules)
+		res.audit = settingsAction("faction_rules", cfg.Factions, rules)
+		res.message = "Правила фракций обновлены."
 	default:
 		err = errors.New("Неизвестное действие")
 	}
-
-	if err != nil {
-		h.render(w, viewData{Error: err.Error()})
-		return
-	}
-	h.render(w, viewData{Message: message})
+	return res, err
 }
 
-func (h *Handler) applyRecalc(ctx context.Context) (string, error) {
-	cfg, err := h.store.GetSystemConfig(ctx)
+func (h *Handler) applyRecalc(ctx context.Context, store *db.Store) (string, db.AdminAction, error) {
+	cfg, err := store.GetSystemConfig(ctx)
 	if err != nil {
-		return "", err
+		return "", db.AdminAction{}, err
 	}
-	cycle, err := h.store.EnsureActiveCycle(ctx, cfg)
+	cycle, err := store.EnsureActiveCycle(ctx, cfg)
 	if err != nil {
-		return "", err
+		return "", db.AdminAction{}, err
 	}
-	boundaries, err := h.store.GetLevelBoundaries(ctx, cycle.ID)
+	boundaries, err := store.GetLevelBoundaries(ctx, cycle.ID)
 	if err != nil {
-		return "", err
+		return "", db.AdminAction{}, err
 	}
 	if len(boundaries) == 0 {
-		return "", errors.New("Границы уровней не заданы")
+		return "", db.AdminAction{}, errors.New("Границы уровней не заданы")
 	}
-	if err := h.store.RecalculateLevels(ctx, cycle.ID, boundaries); err != nil {
-		return "", err
+	if err := store.RecalculateLevels(ctx, cycle.ID, boundaries, 0); err != nil {
+		return "", db.AdminAction{}, err
+	}
+	audit := db.AdminAction{ActionType: db.ActionForceLevelRecalc, Details: map[string]any{
+		"cycle_number": cycle.CycleNumber,
+		"boundaries":   boundaries,
+	}}
+	return "Пересчет уровней завершен.", audit, nil
+}
+
+func settingsAction(setting string, old, new any) db.AdminAction {
+	return db.AdminAction{ActionType: db.ActionChangeCycleSettings, Details: map[string]any{
+		"setting": setting,
+		"old":     old,
+		"new":     new,
+	}}
+}
+
+func ratingLimitValue(limits []db.RatingLimit, level int) any {
+	for _, limit := range limits {
+		if limit.Level == level {
+			return limit.Limit
+		}
 	}
-	return "Пересчет уровней завершен.", nil
+	return nil
 }
 
-func (h *Handler) render(w http.ResponseWriter, data viewData) {
+func boundaryValues(boundaries []db.LevelBoundary) map[string][2]int {
+	values := make(map[string][2]int, len(boundaries))
+	for _, boundary := range boundaries {
+		values[strconv.Itoa(boundary.Level)] = [2]int{boundary.MinRating, boundary.MaxRating}
+	}
+	return values
+}
+
+func formatFormula(f db.RatingFormula) string {
+	return fmt.Sprintf("%s v%d (%s %s)", f.Name, f.Version, f.Strategy, f.Params)
+}
+
+func (h *Handler) render(w http.ResponseWriter, r *http.Request, data viewData) {
+	ctx := r.Context()
+	if cycle, err := h.store.GetActiveCycle(ctx); err == nil {
+		data.CycleNumber = cycle.CycleNumber
+		if boundaries, err := h.store.ListLevelBoundaries(ctx, cycle.ID); err == nil {
+			data.Boundaries = boundaries
+		}
+	}
+	if formulas, err := h.store.ListFormulas(ctx); err == nil {
+		data.Formulas = formulas
+	}
+	if cycle, err := h.store.GetActiveCycle(ctx); err == nil {
+		if current, err := h.store.GetCycleFormula(ctx, cycle.ID); err == nil {
+			data.Current = &current
+		}
+	}
+	if next, err := h.store.GetNextCycleFormula(ctx); err == nil {
+		data.Next = &next
+	}
+	if cfg, err := h.store.GetSystemConfig(ctx); err == nil {
+		data.FactionRules = cfg.Factions
+		data.HideNumbers = cfg.HideLeaderboardNumbers
+		data.RaterVisibility = cfg.RaterVisibility
+	}
+	if top, err := h.store.TopPlayers(ctx, adminTopSize, 0); err == nil {
+		data.Top = top
+	}
+	if cycle, err := h.store.GetActiveCycle(ctx); err == nil {
+		if standings, err := h.store.FactionLeaderboard(ctx, cycle.ID); err == nil {
+			data.Factions = standings
+		}
+	}
+	data.Strategies = formula.Strategies()
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := h.tpl.Execute(w, data); err != nil {
 		http.Error(w, "template error", http.StatusInternalServerError)
 	}
 }
 
+type dryRunRequest struct {
+	// Name and Version select a saved formula; otherwise Strategy and Params
+	// describe an unsaved one.
+	Name     string          `json:"name"`
+	Version  int             `json:"version"`
+	Strategy string          `json:"strategy"`
+	Params   formula.Params  `json:"params"`
+	Samples  []formula.Input `json:"samples"`
+}
+
+type dryRunResult struct {
+	Input   formula.Input `json:"input"`
+	Like    *dryRunValue  `json:"like,omitempty"`
+	Dislike *dryRunValue  `json:"dislike,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type dryRunValue struct {
+	RawValue       float64 `json:"raw_value"`
+	MinimumApplied bool    `json:"minimum_applied"`
+	Result         int     `json:"result"`
+}
+
+// handleFormulaDryRun evaluates a formula against sample inputs without
+// touching any player. It accepts and returns JSON.
+func (h *Handler) handleFormulaDryRun(w http.ResponseWriter, r *http.Request) {
+	var req dryRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return
+	}
+	candidate := formula.Formula{Strategy: req.Strategy, Params: req.Params}
+	if req.Name != "" {
+		saved, err := h.store.GetFormula(r.Context(), req.Name, req.Version)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "formula not found"})
+			return
+		}
+		candidate = formula.Formula{Strategy: saved.Strategy, Params: saved.Params}
+	}
+	if err := candidate.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	results := make([]dryRunResult, 0, len(req.Samples))
+	for _, sample := range req.Samples {
+		result := dryRunResult{Input: sample}
+		if sample.RaterRating == 0 && sample.RatedRating == 0 {
+			result.Input.RaterRating, result.Input.RatedRating = db.InitialRating, db.InitialRating
+		}
+		for _, sign := range []float64{1, -1} {
+			in := result.Input
+			in.Sign = sign
+			value, err := candidate.Calculate(in, 1)
+			if err != nil {
+				result.Error = err.Error()
+				break
+			}
+			out := &dryRunValue{RawValue: value.RawValue, MinimumApplied: value.MinimumApplied, Result: value.Value}
+			if sign > 0 {
+				result.Like = out
+			} else {
+				result.Dislike = out
+			}
+		}
+		results = append(results, result)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"strategy": candidate.Strategy,
+		"params":   candidate.Resolved(),
+		"results":  results,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
 const adminTemplate = `<!doctype html>
 <html lang="ru">
 <head>
@@ -191,6 +849,8 @@ const adminTemplate = `<!doctype html>
     button { margin-top: 0.75rem; padding: 0.5rem 1rem; }
     .message { color: #0b5; }
     .error { color: #b00; }
+    table { border-collapse: collapse; margin-bottom: 1.5rem; }
+    th, td { border: 1px solid #ccc; padding: 0.3rem 0.6rem; text-align: right; }
   </style>
 </head>
 <body>
@@ -198,6 +858,245 @@ const adminTemplate = `<!doctype html>
   {{if .Message}}<p class="message">{{.Message}}</p>{{end}}
   {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
 
+  {{with .Rating}}
+  <h2>Оценка №{{.ID}}</h2>
+  <table>
+    <tr><th>Цикл</th><td>{{.CycleNumber}}</td></tr>
+    <tr><th>Время</th><td>{{.CreatedAt.Format "02.01.2006 15:04:05"}}</td></tr>
+    <tr><th>Кто</th><td>{{if .RaterHidden}}аноним{{else}}{{.RaterName}} (#{{.RaterID}}){{end}}</td></tr>
+    <tr><th>Кому</th><td>{{.RatedName}} (#{{.RatedID}})</td></tr>
+    <tr><th>Тип</th><td>{{.RatingType}}</td></tr>
+    <tr><th>Изменение</th><td>{{.RatingValue}}</td></tr>
+    <tr><th>Коэффициент штрафа</th><td>{{printf "%.2f" .PenaltyCoefficient}}</td></tr>
+    {{with .Details}}
+    <tr><th>Уровень оценившего</th><td>{{.RaterLevel}}</td></tr>
+    <tr><th>Уровень оцененного</th><td>{{.RatedLevel}}</td></tr>
+    {{if .Formula}}
+    <tr><th>Формула</th><td>{{.Formula}} v{{.FormulaVersion}} ({{.Strategy}} {{.Params}})</td></tr>
+    {{if not .RaterHidden}}<tr><th>Рейтинги</th><td>{{.RaterRating}} → {{.RatedRating}}</td></tr>{{end}}
+    {{else}}
+    <tr><th>A / B</th><td>{{.FormulaA}} / {{.FormulaB}}</td></tr>
+    {{end}}
+    {{if not .RaterHidden}}
+    <tr><th>Исходное значение</th><td>{{printf "%.4f" .RawValue}}</td></tr>
+    <tr><th>Предыдущих оценок</th><td>{{.PreviousRatings}}</td></tr>
+    {{end}}
+    {{with .FactionWeight}}<tr><th>Вес межфракционной оценки</th><td>{{printf "%.2f" .}}</td></tr>{{end}}
+    {{if not .RaterHidden}}<tr><th>После штрафа</th><td>{{printf "%.4f" .PenalizedValue}}</td></tr>{{end}}
+    <tr><th>Округление</th><td>{{.Rounded}}{{if .MinimumApplied}} (минимум ±1){{end}}</td></tr>
+    <tr><th>Итог</th><td>{{.Result}}</td></tr>
+    <tr><th>Версия настроек</th><td>{{.ConfigVersion}}</td></tr>
+    {{else}}
+    <tr><th>Расчет</th><td>не сохранен</td></tr>
+    {{end}}
+  </table>
+  {{end}}
+
+  {{if .Drifts}}
+  <h2>Расхождения рейтинга</h2>
+  <table>
+    <tr><th>Игрок</th><th>В профиле</th><th>По журналу</th><th>Оценки</th><th>Переводы +</th><th>Переводы −</th><th>Корректировки</th><th>Разница</th></tr>
+    {{range .Drifts}}
+    <tr>
+      <td>{{.FullName}} (#{{.PlayerID}})</td>
+      <td>{{.Stored}}</td>
+      <td>{{.Expected}}</td>
+      <td>{{.Ratings}}</td>
+      <td>{{.TransfersIn}}</td>
+      <td>{{.TransfersOut}}</td>
+      <td>{{.Adjustments}}</td>
+      <td>{{.Delta}}</td>
+    </tr>
+    {{end}}
+  </table>
+  {{end}}
+
+  {{if .AdminActions}}
+  <h2>Журнал действий</h2>
+  <table>
+    <tr><th>Время</th><th>Действие</th><th>Кто</th><th>Игрок</th><th>Детали</th><th>Операция</th></tr>
+    {{range .AdminActions}}
+    <tr>
+      <td>{{.CreatedAt.Format "02.01.2006 15:04:05"}}</td>
+      <td>{{.ActionType}}</td>
+      <td>{{if .AdminID}}{{.AdminName}} (#{{.AdminID}}){{else}}{{.Source}}{{end}}</td>
+      <td>{{if .TargetPlayerID}}{{.TargetName}} (#{{.TargetPlayerID}}){{end}}</td>
+      <td>{{range $key, $value := .Details}}{{$key}}: {{$value}}<br />{{end}}</td>
+      <td>{{.OperationLogID}}</td>
+    </tr>
+    {{end}}
+  </table>
+  {{end}}
+
+  {{if .Boundaries}}
+  <h2>Уровни (цикл {{.CycleNumber}})</h2>
+  <table>
+    <tr><th>Уровень</th><th>Мин.</th><th>Макс.</th><th>Цель</th><th>Факт</th><th>Игроков</th></tr>
+    {{range .Boundaries}}
+    <tr>
+      <td>{{.Level}}</td>
+      <td>{{if .UnboundedBelow}}−∞{{else}}{{.MinRating}}{{end}}</td>
+      <td>{{if .UnboundedAbove}}+∞{{else}}{{.MaxRating}}{{end}}</td>
+      <td>{{if .TargetPercentMax}}{{.TargetPercentMin}}–{{.TargetPercentMax}}%{{else}}—{{end}}</td>
+      <td>{{printf "%.2f" .ActualPercentage}}%</td>
+      <td>{{.PlayerCount}}</td>
+    </tr>
+    {{end}}
+  </table>
+  {{end}}
+
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Кто видит авторов оценок</legend>
+      <input type="hidden" name="action" value="set_rater_visibility" />
+      <label><input name="policy" type="radio" value="anonymous" style="width: auto" {{if eq .RaterVisibility "anonymous"}}checked{{end}} /> никто, включая администраторов</label>
+      <label><input name="policy" type="radio" value="admins" style="width: auto" {{if eq .RaterVisibility "admins"}}checked{{end}} /> только модераторы и администраторы</label>
+      <label><input name="policy" type="radio" value="after_cycle" style="width: auto" {{if eq .RaterVisibility "after_cycle"}}checked{{end}} /> все игроки после окончания цикла</label>
+      <button type="submit">Сохранить</button>
+    </fieldset>
+  </form>
+
+  <h2>Рейтинг игроков</h2>
+  {{if .Top}}
+  <table>
+    <tr><th>Место</th><th>Игрок</th><th>Уровень</th><th>Рейтинг</th></tr>
+    {{range .Top}}
+    <tr><td>{{.Rank}}</td><td>{{.Name}} (#{{.PlayerID}})</td><td>{{.Level}}</td><td>{{.Rating}}</td></tr>
+    {{end}}
+  </table>
+  {{end}}
+
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Публичные рейтинги</legend>
+      <input type="hidden" name="action" value="set_leaderboard_numbers" />
+      <label><input name="hide_numbers" type="checkbox" style="width: auto" {{if .HideNumbers}}checked{{end}} /> скрыть от игроков точные рейтинги (только места и уровни)</label>
+      <button type="submit">Сохранить</button>
+    </fieldset>
+  </form>
+
+  <h2>Фракции (цикл {{.CycleNumber}})</h2>
+  {{if .Factions}}
+  <table>
+    <tr><th>Фракция</th><th>Игроков</th><th>За цикл</th><th>На игрока</th><th>Сумма рейтингов</th><th>Средний рейтинг</th></tr>
+    {{range .Factions}}
+    <tr>
+      <td>{{.Name}}</td>
+      <td>{{.Members}}</td>
+      <td>{{.CycleChange}}</td>
+      <td>{{printf "%.1f" .AverageChange}}</td>
+      <td>{{.TotalRating}}</td>
+      <td>{{printf "%.0f" .AverageRating}}</td>
+    </tr>
+    {{end}}
+  </table>
+  {{else}}
+  <p>Фракций пока нет.</p>
+  {{end}}
+
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Создать фракцию</legend>
+      <input type="hidden" name="action" value="create_faction" />
+      <label>Название
+        <input name="name" type="text" maxlength="100" required />
+      </label>
+      <label>Описание
+        <input name="description" type="text" />
+      </label>
+      <button type="submit">Создать</button>
+    </fieldset>
+  </form>
+
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Фракция игрока</legend>
+      <input type="hidden" name="action" value="set_faction" />
+      <label>Telegram ID
+        <input name="telegram_id" type="number" required />
+      </label>
+      <label>Фракция (пусто — исключить)
+        <select name="faction">
+          <option value="">—</option>
+          {{range .Factions}}<option value="{{.Name}}">{{.Name}}</option>{{end}}
+        </select>
+      </label>
+      <button type="submit">Сохранить</button>
+    </fieldset>
+  </form>
+
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Правила фракций</legend>
+      <input type="hidden" name="action" value="set_faction_rules" />
+      <label><input name="forbid_own_likes" type="checkbox" style="width: auto" {{if .FactionRules.ForbidOwnLikes}}checked{{end}} /> запретить лайки своей фракции</label>
+      <label>Вес межфракционных оценок
+        <input name="cross_weight" type="number" min="0" max="99.99" step="0.01" value="{{printf "%.2f" .FactionRules.CrossWeight}}" required />
+      </label>
+      <button type="submit">Сохранить правила</button>
+    </fieldset>
+  </form>
+
+  <h2>Формулы рейтинга</h2>
+  <p>Текущий цикл: {{with .Current}}{{.Name}} v{{.Version}} ({{.Strategy}} {{.Params}}){{else}}—{{end}}<br />
+  Со следующего цикла: {{with .Next}}{{.Name}} v{{.Version}} ({{.Strategy}} {{.Params}}){{else}}—{{end}}</p>
+  <table>
+    <tr><th>Стратегия</th><th>Описание</th><th>Параметры по умолчанию</th></tr>
+    {{range .Strategies}}
+    <tr><td>{{.Name}}</td><td>{{.Description}}</td><td>{{.Defaults}}</td></tr>
+    {{end}}
+  </table>
+  {{if .Formulas}}
+  <table>
+    <tr><th>Имя</th><th>Версия</th><th>Стратегия</th><th>Параметры</th><th>Описание</th></tr>
+    {{range .Formulas}}
+    <tr><td>{{.Name}}</td><td>{{.Version}}</td><td>{{.Strategy}}</td><td>{{.Params}}</td><td>{{.Description}}</td></tr>
+    {{end}}
+  </table>
+  {{end}}
+
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Сохранить формулу</legend>
+      <input type="hidden" name="action" value="save_formula" />
+      <label>Имя
+        <input name="name" type="text" required />
+      </label>
+      <label>Стратегия
+        <select name="strategy">
+          {{range .Strategies}}<option value="{{.Name}}">{{.Name}}</option>{{end}}
+        </select>
+      </label>
+      <label>Параметры (a=1 b=2)
+        <input name="params" type="text" />
+      </label>
+      <label>Описание
+        <input name="description" type="text" />
+      </label>
+      <button type="submit">Сохранить новую версию</button>
+    </fieldset>
+  </form>
+
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Выбрать формулу</legend>
+      <input type="hidden" name="action" value="use_formula" />
+      <label>Имя
+        <input name="name" type="text" required />
+      </label>
+      <label>Версия (пусто — последняя)
+        <input name="version" type="number" min="1" />
+      </label>
+      <label>Применить
+        <select name="scope">
+          <option value="next">со следующего цикла</option>
+          <option value="current">к текущему циклу</option>
+        </select>
+      </label>
+      <button type="submit">Выбрать</button>
+    </fieldset>
+  </form>
+
   <form method="post" action="/admin/action">
     <fieldset>
       <legend>Циклы</legend>
@@ -220,6 +1119,23 @@ const adminTemplate = `<!doctype html>
     </fieldset>
   </form>
 
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Штраф за повторные оценки</legend>
+      <input type="hidden" name="action" value="set_repeat_penalty" />
+      <label>Шаг (0-1)
+        <input name="step" type="number" min="0" max="1" step="0.01" required />
+      </label>
+      <label>Минимальный коэффициент (0-1)
+        <input name="min" type="number" min="0" max="1" step="0.01" required />
+      </label>
+      <label>Окно (минуты, 0 — в пределах цикла)
+        <input name="window_minutes" type="number" min="0" value="0" required />
+      </label>
+      <button type="submit">Обновить штраф</button>
+    </fieldset>
+  </form>
+
   <form method="post" action="/admin/action">
     <fieldset>
       <legend>Лимит оценок</legend>
@@ -230,10 +1146,47 @@ const adminTemplate = `<!doctype html>
       <label>Лимит
         <input name="limit" type="number" min="1" required />
       </label>
+      <label>Применить
+        <select name="scope">
+          <option value="next">со следующего цикла</option>
+          <option value="current">к текущему циклу</option>
+        </select>
+      </label>
       <button type="submit">Обновить лимит</button>
     </fieldset>
   </form>
 
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Изменить рейтинг игрока</legend>
+      <input type="hidden" name="action" value="adjust_rating" />
+      <label>Telegram ID
+        <input name="telegram_id" type="number" required />
+      </label>
+      <label>Изменение (+/-)
+        <input name="delta" type="number" required />
+      </label>
+      <label>Причина
+        <input name="reason" type="text" required />
+      </label>
+      <button type="submit">Изменить</button>
+    </fieldset>
+  </form>
+
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Вернуть оценки игроку</legend>
+      <input type="hidden" name="action" value="refund_ratings" />
+      <label>Telegram ID
+        <input name="telegram_id" type="number" required />
+      </label>
+      <label>Количество
+        <input name="count" type="number" min="1" required />
+      </label>
+      <button type="submit">Вернуть</button>
+    </fieldset>
+  </form>
+
   <form method="post" action="/admin/action">
     <fieldset>
       <legend>Границы уровней</legend>
@@ -251,6 +1204,19 @@ const adminTemplate = `<!doctype html>
     </fieldset>
   </form>
 
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Распределение по уровням (%)</legend>
+      <input type="hidden" name="action" value="set_level_distribution" />
+      <label>Уровень 1 <input name="share_1" type="number" min="1" max="100" value="10" required /></label>
+      <label>Уровень 2 <input name="share_2" type="number" min="1" max="100" value="20" /></label>
+      <label>Уровень 3 <input name="share_3" type="number" min="1" max="100" value="40" /></label>
+      <label>Уровень 4 <input name="share_4" type="number" min="1" max="100" value="20" /></label>
+      <label>Уровень 5 <input name="share_5" type="number" min="1" max="100" value="10" /></label>
+      <button type="submit">Рассчитать границы</button>
+    </fieldset>
+  </form>
+
   <form method="post" action="/admin/action">
     <fieldset>
       <legend>Пересчет уровней</legend>
@@ -259,6 +1225,54 @@ const adminTemplate = `<!doctype html>
     </fieldset>
   </form>
 
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Сверка рейтингов</legend>
+      <input type="hidden" name="action" value="check_ledger" />
+      <label><input name="repair" type="checkbox" style="width: auto" /> исправить расхождения</label>
+      <button type="submit">Проверить</button>
+    </fieldset>
+  </form>
+
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Журнал действий</legend>
+      <input type="hidden" name="action" value="admin_log" />
+      <label>Тип действия
+        <select name="action_type">
+          <option value="">все</option>
+          <option value="change_cycle_settings">change_cycle_settings</option>
+          <option value="set_rating_limits">set_rating_limits</option>
+          <option value="refund_ratings">refund_ratings</option>
+          <option value="set_level_boundaries">set_level_boundaries</option>
+          <option value="force_level_recalc">force_level_recalc</option>
+          <option value="adjust_rating">adjust_rating</option>
+          <option value="change_rating_formula">change_rating_formula</option>
+          <option value="create_player">create_player</option>
+          <option value="create_admin">create_admin</option>
+          <option value="change_player_role">change_player_role</option>
+          <option value="create_faction">create_faction</option>
+          <option value="set_player_faction">set_player_faction</option>
+        </select>
+      </label>
+      <label>Telegram ID игрока или админа
+        <input name="telegram_id" type="number" />
+      </label>
+      <button type="submit">Показать</button>
+    </fieldset>
+  </form>
+
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Расчет оценки</legend>
+      <input type="hidden" name="action" value="rating_details" />
+      <label>Номер оценки
+        <input name="rating_id" type="number" min="1" required />
+      </label>
+      <button type="submit">Показать</button>
+    </fieldset>
+  </form>
+
   <form method="post" action="/admin/action">
     <fieldset>
       <legend>Добавить игрока</legend>
@@ -273,6 +1287,25 @@ const adminTemplate = `<!doctype html>
     </fieldset>
   </form>
 
+  <form method="post" action="/admin/action">
+    <fieldset>
+      <legend>Роль игрока</legend>
+      <input type="hidden" name="action" value="set_role" />
+      <label>Telegram ID
+        <input name="telegram_id" type="number" required />
+      </label>
+      <label>Роль
+        <select name="role">
+          <option value="player">player</option>
+          <option value="moderator">moderator</option>
+          <option value="admin">admin</option>
+          <option value="super_admin">super_admin</option>
+        </select>
+      </label>
+      <button type="submit">Сменить роль</button>
+    </fieldset>
+  </form>
+
   <form method="post" action="/admin/action">
     <fieldset>
       <legend>Назначить администратора</legend>
@@ -3,34 +3,40 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	DatabaseURL    string
-	TelegramToken  string
-	WebhookURL     string
-	WebhookPath    string
-	WebhookCert    string
-	ServerAddr     string
-	MigrateOnStart bool
-	ConfigCacheTTL time.Duration
-	AdminToken     string
-	BotLinkBase    string
+	DatabaseURL       string
+	TelegramToken     string
+	WebhookURL        string
+	WebhookPath       string
+	WebhookCert       string
+	ServerAddr        string
+	MigrateOnStart    bool
+	ConfigCacheTTL    time.Duration
+	AdminToken        string
+	BotLinkBase       string
+	CyclePollInterval time.Duration
+	// CharacterClasses are the in-game classes players may pick in /register.
+	CharacterClasses []string
 }
 
 func Load() Config {
 	return Config{
-		DatabaseURL:    getEnv("DATABASE_URL", ""),
-		TelegramToken:  getEnv("TELEGRAM_TOKEN", ""),
-		WebhookURL:     getEnv("WEBHOOK_URL", ""),
-		WebhookPath:    getEnv("WEBHOOK_PATH", "/webhook"),
-		WebhookCert:    getEnv("WEBHOOK_CERT", ""),
-		ServerAddr:     getEnv("SERVER_ADDR", ":8080"),
-		MigrateOnStart: getEnvBool("MIGRATE_ON_START", true),
-		ConfigCacheTTL: getEnvDuration("CONFIG_CACHE_TTL", 30*time.Second),
-		AdminToken:     getEnv("ADMIN_TOKEN", ""),
-		BotLinkBase:    getEnv("BOT_LINK_BASE", "https://t.me/novy_rim_bot"),
+		DatabaseURL:       getEnv("DATABASE_URL", ""),
+		TelegramToken:     getEnv("TELEGRAM_TOKEN", ""),
+		WebhookURL:        getEnv("WEBHOOK_URL", ""),
+		WebhookPath:       getEnv("WEBHOOK_PATH", "/webhook"),
+		WebhookCert:       getEnv("WEBHOOK_CERT", ""),
+		ServerAddr:        getEnv("SERVER_ADDR", ":8080"),
+		MigrateOnStart:    getEnvBool("MIGRATE_ON_START", true),
+		ConfigCacheTTL:    getEnvDuration("CONFIG_CACHE_TTL", 30*time.Second),
+		AdminToken:        getEnv("ADMIN_TOKEN", ""),
+		BotLinkBase:       getEnv("BOT_LINK_BASE", "https://t.me/novy_rim_bot"),
+		CyclePollInterval: getEnvDuration("CYCLE_POLL_INTERVAL", 30*time.Second),
+		CharacterClasses:  getEnvList("CHARACTER_CLASSES", []string{"патриций", "плебей", "легионер", "жрец", "торговец", "раб"}),
 	}
 }
 
@@ -42,6 +48,19 @@ func getEnv(key, fallback string) string {
 	return value
 }
 
+func getEnvList(key string, fallback []string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return fallback
+	}
+	return values
+}
+
 func getEnvBool(key string, fallback bool) bool {
 	value := os.Getenv(key)
 	if value == "" {
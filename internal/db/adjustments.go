@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RatingAdjustment is a manual change of a player's rating by a game master,
+// e.g. a quest reward or a penalty for breaking the rules.
+type RatingAdjustment struct {
+	ID        int64
+	PlayerID  int
+	AdminID   *int
+	Amount    int
+	Reason    string
+	CycleID   *int
+	CreatedAt time.Time
+}
+
+// AdjustRating changes the player's rating by adjustment.Amount. The
+// adjustment, the rating change, its operations_log entry and the
+// adjust_rating admin action are written in one transaction. It returns the
+// stored adjustment and the player after the change.
+func (s *Store) AdjustRating(ctx context.Context, adjustment RatingAdjustment, source string) (RatingAdjustment, Player, error) {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return RatingAdjustment{}, Player{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	players, err := lockPlayers(ctx, tx, adjustment.PlayerID)
+	if err != nil {
+		return RatingAdjustment{}, Player{}, err
+	}
+	player := players[adjustment.PlayerID]
+
+	cycle, err := getActiveCycle(ctx, tx)
+	switch {
+	case err == nil:
+		adjustment.CycleID = &cycle.ID
+	case !errors.Is(err, pgx.ErrNoRows):
+		return RatingAdjustment{}, Player{}, err
+	}
+
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO rating_adjustments (player_id, admin_id, amount, reason, game_cycle_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, adjustment.PlayerID, adjustment.AdminID, adjustment.Amount, adjustment.Reason, adjustment.CycleID).Scan(&adjustment.ID, &adjustment.CreatedAt); err != nil {
+		return RatingAdjustment{}, Player{}, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE players SET current_rating = current_rating + $1, updated_at = NOW() WHERE id = $2
+	`, adjustment.Amount, adjustment.PlayerID); err != nil {
+		return RatingAdjustment{}, Player{}, err
+	}
+
+	entry := operationEntry{
+		Type:         "rating_adjustment",
+		CycleID:      adjustment.CycleID,
+		Target:       snapshot(player, adjustment.Amount),
+		RatingChange: &adjustment.Amount,
+		Details: map[string]any{
+			"adjustment_id": adjustment.ID,
+			"reason":        adjustment.Reason,
+			"source":        source,
+		},
+	}
+	if adjustment.AdminID != nil {
+		if entry.Initiator, err = playerSnapshotByID(ctx, tx, *adjustment.AdminID); err != nil {
+			return RatingAdjustment{}, Player{}, err
+		}
+	}
+	operationID, err := logOperation(ctx, tx, entry)
+	if err != nil {
+		return RatingAdjustment{}, Player{}, err
+	}
+	if err := insertAdminAction(ctx, tx, AdminAction{
+		AdminID:        adjustment.AdminID,
+		Source:         source,
+		ActionType:     ActionAdjustRating,
+		TargetPlayerID: &adjustment.PlayerID,
+		Details: map[string]any{
+			"adjustment_id": adjustment.ID,
+			"amount":        adjustment.Amount,
+			"reason":        adjustment.Reason,
+			"old":           player.Rating,
+			"new":           player.Rating + adjustment.Amount,
+		},
+	}, operationID); err != nil {
+		return RatingAdjustment{}, Player{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return RatingAdjustment{}, Player{}, err
+	}
+	player.Rating += adjustment.Amount
+	return adjustment, player, nil
+}
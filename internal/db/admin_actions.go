@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ActionCreatePlayer        = "create_player"
+	ActionAdjustRating        = "adjust_rating"
+	ActionChangeCycleSettings = "change_cycle_settings"
+	ActionCreateAdmin         = "create_admin"
+	ActionChangePlayerRole    = "change_player_role"
+	ActionForceLevelRecalc    = "force_level_recalc"
+	ActionSetRatingLimits     = "set_rating_limits"
+	ActionRefundRatings       = "refund_ratings"
+	ActionSetLevelBoundaries  = "set_level_boundaries"
+	ActionChangeRatingFormula = "change_rating_formula"
+	ActionCreateFaction       = "create_faction"
+	ActionSetPlayerFaction    = "set_player_faction"
+)
+
+const (
+	AdminSourceBot = "bot"
+	AdminSourceWeb = "web"
+)
+
+// AdminAction is an audit entry for a privileged change. AdminID is nil for
+// changes made through the token-protected web admin, which has no player
+// behind it.
+type AdminAction struct {
+	ID             int64
+	AdminID        *int
+	AdminName      string
+	Source         string
+	ActionType     string
+	TargetPlayerID *int
+	TargetName     string
+	Details        map[string]any
+	OperationLogID int64
+	CreatedAt      time.Time
+}
+
+// AdminActionFilter narrows ListAdminActions. Zero fields match everything;
+// PlayerID matches both the acting admin and the target player.
+type AdminActionFilter struct {
+	ActionType string
+	PlayerID   int
+	Limit      int
+}
+
+// auditLockKey is the Postgres advisory lock that serializes audited changes,
+// so the old values in their details are the ones actually replaced.
+const auditLockKey int64 = 0x5254534155444954
+
+// Audit applies a privileged change and its audit entry in one transaction.
+// change runs against a Store bound to that transaction and fills in the
+// details and target of action, reading old values through tx. The entry is
+// skipped when change leaves ActionType empty; nothing is kept when change
+// fails.
+func (s *Store) Audit(ctx context.Context, action AdminAction, change func(tx *Store, action *AdminAction) error) error {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", auditLockKey); err != nil {
+		return err
+	}
+	if err := change(&Store{conn: tx}, &action); err != nil {
+		return err
+	}
+	if action.ActionType != "" {
+		if err := recordAdminAction(ctx, tx, action); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func recordAdminAction(ctx context.Context, q querier, action AdminAction) error {
+	if action.Source == "" {
+		action.Source = AdminSourceBot
+	}
+	entry := operationEntry{
+		Type: "admin_action",
+		Details: map[string]any{
+			"action":  action.ActionType,
+			"source":  action.Source,
+			"details": action.Details,
+		},
+	}
+	var err error
+	if action.AdminID != nil {
+		if entry.Initiator, err = playerSnapshotByID(ctx, q, *action.AdminID); err != nil {
+			return err
+		}
+	}
+	if action.TargetPlayerID != nil {
+		if entry.Target, err = playerSnapshotByID(ctx, q, *action.TargetPlayerID); err != nil {
+			return err
+		}
+	}
+	cycle, err := getActiveCycle(ctx, q)
+	switch {
+	case err == nil:
+		entry.CycleID = &cycle.ID
+	case !errors.Is(err, pgx.ErrNoRows):
+		return err
+	}
+	operationID, err := logOperation(ctx, q, entry)
+	if err != nil {
+		return err
+	}
+	return insertAdminAction(ctx, q, action, operationID)
+}
+
+func (s *Store) ListAdminActions(ctx context.Context, filter AdminActionFilter) ([]AdminAction, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 20
+	}
+	rows, err := s.conn.Query(ctx, `
+		SELECT aa.id, aa.admin_id, COALESCE(admin.full_name, ''), aa.source, aa.action_type,
+			aa.target_player_id, COALESCE(target.full_name, ''), aa.details,
+			COALESCE(aa.operation_log_id, 0), aa.created_at
+		FROM admin_actions aa
+		LEFT JOIN players admin ON admin.id = aa.admin_id
+		LEFT JOIN players target ON target.id = aa.target_player_id
+		WHERE ($1 = '' OR aa.action_type = $1)
+			AND ($2 = 0 OR aa.admin_id = $2 OR aa.target_player_id = $2)
+		ORDER BY aa.created_at DESC, aa.id DESC
+		LIMIT $3
+	`, filter.ActionType, filter.PlayerID, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (AdminAction, error) {
+		var action AdminAction
+		err := row.Scan(&action.ID, &action.AdminID, &action.AdminName, &action.Source, &action.ActionType,
+			&action.TargetPlayerID, &action.TargetName, &action.Details,
+			&action.OperationLogID, &action.CreatedAt)
+		return action, err
+	})
+}
+
+func insertAdminAction(ctx context.Context, q querier, action AdminAction, operationID int64) error {
+	if action.Source == "" {
+		action.Source = AdminSourceBot
+	}
+	details, err := json.Marshal(action.Details)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, `
+		INSERT INTO admin_actions (admin_id, source, action_type, target_player_id, details, operation_log_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, action.AdminID, action.Source, action.ActionType, action.TargetPlayerID, string(details), operationID)
+	return err
+}
+
+func playerSnapshotByID(ctx context.Context, q querier, playerID int) (*playerSnapshot, error) {
+	player, err := scanPlayer(q.QueryRow(ctx, `SELECT `+playerColumns+` FROM players WHERE id = $1`, playerID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPlayerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return snapshot(player, 0), nil
+}
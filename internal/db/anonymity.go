@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"errors"
+)
+
+// Rater visibility policies. Whatever the policy, a rater always sees the
+// ratings they gave.
+const (
+	// RatersAnonymous hides raters from everyone, admins included.
+	RatersAnonymous = "anonymous"
+	// RatersAdmins reveals raters to moderators and above only.
+	RatersAdmins = "admins"
+	// RatersAfterCycle reveals raters to everyone once the rating's cycle has
+	// ended, and to moderators and above at any time.
+	RatersAfterCycle = "after_cycle"
+)
+
+var ErrInvalidRaterVisibility = errors.New("invalid rater visibility")
+
+func IsRaterVisibility(value string) bool {
+	switch value {
+	case RatersAnonymous, RatersAdmins, RatersAfterCycle:
+		return true
+	}
+	return false
+}
+
+// Viewer is whoever reads ratings. PlayerID is nil for the web admin.
+// Every store method that returns raters takes a Viewer and hides the rater
+// according to the current policy, so callers cannot leak it by accident.
+type Viewer struct {
+	PlayerID *int
+	Role     string
+}
+
+func PlayerViewer(player Player) Viewer {
+	return Viewer{PlayerID: &player.ID, Role: player.Role}
+}
+
+// CanSeeRater applies the policy to a single rating.
+func (v Viewer) CanSeeRater(policy string, raterID int, cycleEnded bool) bool {
+	if v.PlayerID != nil && *v.PlayerID == raterID {
+		return true
+	}
+	switch policy {
+	case RatersAdmins:
+		return HasRole(v.Role, RoleModerator)
+	case RatersAfterCycle:
+		return cycleEnded || HasRole(v.Role, RoleModerator)
+	default:
+		return false
+	}
+}
+
+func (s *Store) UpdateRaterVisibility(ctx context.Context, policy string) error {
+	if !IsRaterVisibility(policy) {
+		return ErrInvalidRaterVisibility
+	}
+	_, err := s.conn.Exec(ctx, `
+		UPDATE system_config
+		SET rater_visibility = $1, version = version + 1, updated_at = NOW()
+		WHERE id = (SELECT id FROM system_config ORDER BY id DESC LIMIT 1)
+	`, policy)
+	return err
+}
+
+// raterVisibility reads the current policy. Without a config row raters stay
+// hidden.
+func raterVisibility(ctx context.Context, q querier) (string, error) {
+	var policy string
+	err := q.QueryRow(ctx, `SELECT rater_visibility FROM system_config ORDER BY id DESC LIMIT 1`).Scan(&policy)
+	if err != nil {
+		return RatersAnonymous, err
+	}
+	return policy, nil
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CharacterProfile is the in-game persona of a player, kept apart from the
+// real name in players.full_name. CharacterClass is a class from the
+// configured list and has nothing to do with the permission role in
+// players.role. Faction is the name of the faction an admin assigned; it is
+// read-only here.
+type CharacterProfile struct {
+	PlayerID       int
+	CharacterName  string
+	CharacterClass string
+	Faction        string
+	Bio            string
+	PhotoFileID    string
+}
+
+// DisplayName is the character name, or the real name when the player has
+// not registered a character.
+func (p CharacterProfile) DisplayName(player Player) string {
+	if p.CharacterName != "" {
+		return p.CharacterName
+	}
+	return player.FullName
+}
+
+// PublicName is the character name, or an anonymized label when the player
+// has not registered a character. Places anyone can see, such as rankings,
+// inline results and shared cards, use it instead of DisplayName.
+func (p CharacterProfile) PublicName(player Player) string {
+	if p.CharacterName != "" {
+		return p.CharacterName
+	}
+	return fmt.Sprintf(anonymousNameFormat, player.ID)
+}
+
+// SaveCharacterProfile stores the whole profile. It never touches the
+// permission role.
+func (s *Store) SaveCharacterProfile(ctx context.Context, profile CharacterProfile) error {
+	_, err := s.conn.Exec(ctx, `
+		INSERT INTO character_profiles (player_id, character_name, character_class, bio, photo_file_id)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''))
+		ON CONFLICT (player_id) DO UPDATE
+		SET character_name = EXCLUDED.character_name,
+			character_class = EXCLUDED.character_class,
+			bio = EXCLUDED.bio,
+			photo_file_id = EXCLUDED.photo_file_id,
+			updated_at = NOW()
+	`, profile.PlayerID, profile.CharacterName, profile.CharacterClass, profile.Bio, profile.PhotoFileID)
+	return err
+}
+
+// GetCharacterProfile returns an empty profile for players who never
+// registered a character.
+func (s *Store) GetCharacterProfile(ctx context.Context, playerID int) (CharacterProfile, error) {
+	profile := CharacterProfile{PlayerID: playerID}
+	err := s.conn.QueryRow(ctx, `
+		SELECT COALESCE(cp.character_name, ''), COALESCE(cp.character_class, ''), COALESCE(f.name, ''),
+			COALESCE(cp.bio, ''), COALESCE(cp.photo_file_id, '')
+		FROM players p
+		LEFT JOIN character_profiles cp ON cp.player_id = p.id
+		LEFT JOIN factions f ON f.id = p.faction_id
+		WHERE p.id = $1
+	`, playerID).Scan(&profile.CharacterName, &profile.CharacterClass, &profile.Faction, &profile.Bio, &profile.PhotoFileID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return profile, nil
+	}
+	return profile, err
+}
+
+func (s *Store) UpdatePlayerName(ctx context.Context, playerID int, fullName string) error {
+	_, err := s.conn.Exec(ctx, `
+		UPDATE players SET full_name = $1, updated_at = NOW() WHERE id = $2
+	`, fullName, playerID)
+	return err
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// cycleLockKey is the Postgres advisory lock that serializes cycle rotation
+// between application replicas.
+const cycleLockKey int64 = 0x52545343594c45
+
+func (s *Store) GetActiveCycle(ctx context.Context) (GameCycle, error) {
+	return getActiveCycle(ctx, s.conn)
+}
+
+func (s *Store) EnsureActiveCycle(ctx context.Context, cfg SystemConfig) (GameCycle, error) {
+	cycle, err := s.GetActiveCycle(ctx)
+	if err == nil && time.Now().Before(cycle.EndTime) {
+		return cycle, nil
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return GameCycle{}, err
+	}
+	return s.AdvanceCycle(ctx, cfg)
+}
+
+// AdvanceCycle closes every active cycle whose end_time has passed, runs the
+// pending end-of-cycle level recalculations and opens the next cycle when no
+// cycle is active. It is safe to call concurrently from several replicas: the
+// work runs under a session advisory lock, or a transaction one inside Audit.
+func (s *Store) AdvanceCycle(ctx context.Context, cfg SystemConfig) (GameCycle, error) {
+	if s.pool == nil {
+		if _, err := s.conn.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", cycleLockKey); err != nil {
+			return GameCycle{}, err
+		}
+		return advanceCycle(ctx, s.conn, cfg)
+	}
+
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return GameCycle{}, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", cycleLockKey); err != nil {
+		return GameCycle{}, err
+	}
+	defer unlockCycles(conn)
+	return advanceCycle(ctx, conn, cfg)
+}
+
+func advanceCycle(ctx context.Context, conn beginner, cfg SystemConfig) (GameCycle, error) {
+	now := time.Now().UTC()
+	if err := closeExpiredCycles(ctx, conn, now); err != nil {
+		return GameCycle{}, err
+	}
+	if err := recalculateClosedCycles(ctx, conn); err != nil {
+		return GameCycle{}, err
+	}
+
+	cycle, err := getActiveCycle(ctx, conn)
+	if err == nil {
+		return cycle, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return GameCycle{}, err
+	}
+	return openCycle(ctx, conn, cfg, now)
+}
+
+func unlockCycles(conn *pgxpool.Conn) {
+	// The unlock must run even when the request context is already cancelled,
+	// otherwise the lock would travel back to the pool with the connection.
+	if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", cycleLockKey); err != nil {
+		_ = conn.Conn().Close(context.Background())
+	}
+}
+
+func getActiveCycle(ctx context.Context, q querier) (GameCycle, error) {
+	var cycle GameCycle
+	row := q.QueryRow(ctx, `
+		SELECT id, cycle_number, start_time, end_time, duration_minutes, rating_timeout_minutes
+		FROM game_cycles
+		WHERE is_active = TRUE
+		ORDER BY start_time DESC
+		LIMIT 1
+	`)
+	if err := row.Scan(&cycle.ID, &cycle.CycleNumber, &cycle.StartTime, &cycle.EndTime, &cycle.DurationMinutes, &cycle.RatingTimeoutMinutes); err != nil {
+		return GameCycle{}, err
+	}
+	return cycle, nil
+}
+
+func closeExpiredCycles(ctx context.Context, conn beginner, now time.Time) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	rows, err := tx.Query(ctx, `
+		UPDATE game_cycles
+		SET is_active = FALSE, updated_at = NOW()
+		WHERE is_active = TRUE AND end_time <= $1
+		RETURNING id, cycle_number, start_time, end_time, duration_minutes, rating_timeout_minutes
+	`, now)
+	if err != nil {
+		return err
+	}
+	closed, err := scanCycles(rows)
+	if err != nil {
+		return err
+	}
+
+	for _, cycle := range closed {
+		if err := logCycleEvent(ctx, tx, "cycle_end", cycle); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func openCycle(ctx context.Context, conn beginner, cfg SystemConfig, now time.Time) (GameCycle, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return GameCycle{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var (
+		nextNumber int
+		lastEnd    *time.Time
+	)
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(MAX(cycle_number), 0) + 1, MAX(end_time) FROM game_cycles
+	`).Scan(&nextNumber, &lastEnd); err != nil {
+		return GameCycle{}, err
+	}
+
+	duration := time.Duration(cfg.DefaultCycleDuration) * time.Minute
+	start := now
+	// Keep cycles back to back when the previous one has just ended, so a
+	// slightly late tick does not shift the whole schedule.
+	if lastEnd != nil && now.Sub(*lastEnd) < duration {
+		start = lastEnd.UTC()
+	}
+	end := start.Add(duration)
+
+	var created GameCycle
+	row := tx.QueryRow(ctx, `
+		INSERT INTO game_cycles (cycle_number, start_time, end_time, duration_minutes, rating_timeout_minutes, is_active, rating_formula_id)
+		VALUES ($1, $2, $3, $4, $5, TRUE, (SELECT rating_formula_id FROM system_config ORDER BY id DESC LIMIT 1))
+		RETURNING id, cycle_number, start_time, end_time, duration_minutes, rating_timeout_minutes
+	`, nextNumber, start, end, cfg.DefaultCycleDuration, cfg.DefaultRatingTimeout)
+	if err := row.Scan(&created.ID, &created.CycleNumber, &created.StartTime, &created.EndTime, &created.DurationMinutes, &created.RatingTimeoutMinutes); err != nil {
+		return GameCycle{}, err
+	}
+
+	if err := copyLevelBoundaries(ctx, tx, created.ID); err != nil {
+		return GameCycle{}, err
+	}
+	if err := snapshotRatingLimits(ctx, tx, created.ID); err != nil {
+		return GameCycle{}, err
+	}
+	if err := resetRatingBudgets(ctx, tx, created.ID); err != nil {
+		return GameCycle{}, err
+	}
+	if err := logCycleEvent(ctx, tx, "cycle_start", created); err != nil {
+		return GameCycle{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return GameCycle{}, err
+	}
+	return created, nil
+}
+
+func logCycleEvent(ctx context.Context, q querier, operationType string, cycle GameCycle) error {
+	_, err := logOperation(ctx, q, operationEntry{
+		Type:    operationType,
+		CycleID: &cycle.ID,
+		Details: map[string]any{
+			"cycle_number":     cycle.CycleNumber,
+			"start_time":       cycle.StartTime,
+			"end_time":         cycle.EndTime,
+			"duration_minutes": cycle.DurationMinutes,
+		},
+	})
+	return err
+}
+
+func scanCycles(rows pgx.Rows) ([]GameCycle, error) {
+	defer rows.Close()
+
+	var cycles []GameCycle
+	for rows.Next() {
+		var cycle GameCycle
+		if err := rows.Scan(&cycle.ID, &cycle.CycleNumber, &cycle.StartTime, &cycle.EndTime, &cycle.DurationMinutes, &cycle.RatingTimeoutMinutes); err != nil {
+			return nil, err
+		}
+		cycles = append(cycles, cycle)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return cycles, nil
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNoDialog is returned when the user has no dialog in progress.
+var ErrNoDialog = errors.New("no dialog in progress")
+
+// DialogState is a multi-step conversation in progress. It lives in Postgres
+// so that any replica can continue it and it survives restarts.
+type DialogState struct {
+	TelegramID int64
+	Dialog     string
+	Step       string
+	Data       map[string]string
+}
+
+// dialogTTL bounds how long an abandoned dialog keeps intercepting messages.
+const dialogTTL = "24 hours"
+
+func (s *Store) GetDialog(ctx context.Context, telegramID int64) (DialogState, error) {
+	state := DialogState{TelegramID: telegramID}
+	err := s.conn.QueryRow(ctx, `
+		SELECT dialog, step, data
+		FROM dialog_states
+		WHERE telegram_id = $1 AND updated_at > NOW() - $2::interval
+	`, telegramID, dialogTTL).Scan(&state.Dialog, &state.Step, &state.Data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DialogState{}, ErrNoDialog
+	}
+	if err != nil {
+		return DialogState{}, err
+	}
+	if state.Data == nil {
+		state.Data = map[string]string{}
+	}
+	return state, nil
+}
+
+func (s *Store) SaveDialog(ctx context.Context, state DialogState) error {
+	if state.Data == nil {
+		state.Data = map[string]string{}
+	}
+	_, err := s.conn.Exec(ctx, `
+		INSERT INTO dialog_states (telegram_id, dialog, step, data)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (telegram_id) DO UPDATE
+		SET dialog = EXCLUDED.dialog, step = EXCLUDED.step, data = EXCLUDED.data, updated_at = NOW()
+	`, state.TelegramID, state.Dialog, state.Step, state.Data)
+	return err
+}
+
+func (s *Store) DeleteDialog(ctx context.Context, telegramID int64) error {
+	_, err := s.conn.Exec(ctx, `DELETE FROM dialog_states WHERE telegram_id = $1`, telegramID)
+	return err
+}
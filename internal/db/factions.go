@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrFactionNotFound = errors.New("faction not found")
+	ErrFactionExists   = errors.New("faction already exists")
+	ErrOwnFactionLike  = errors.New("likes within one's own faction are forbidden")
+)
+
+type Faction struct {
+	ID          int
+	Name        string
+	Description string
+	Members     int
+}
+
+// FactionRules are the optional rating rules between factions. CrossWeight
+// scales ratings between players of different factions; players without a
+// faction are never affected.
+type FactionRules struct {
+	ForbidOwnLikes bool    `json:"forbid_own_likes"`
+	CrossWeight    float64 `json:"cross_weight"`
+}
+
+// Weight returns the formula weight of a rating from rater to rated.
+func (r FactionRules) Weight(rater, rated Player) float64 {
+	if rater.FactionID == nil || rated.FactionID == nil || *rater.FactionID == *rated.FactionID {
+		return 1
+	}
+	return r.CrossWeight
+}
+
+// FactionStanding is a faction's place in the leaderboard of a cycle.
+// CycleChange sums everything that moved the members' ratings during the
+// cycle: ratings, transfers and manual adjustments.
+type FactionStanding struct {
+	FactionID     int
+	Name          string
+	Members       int
+	TotalRating   int
+	AverageRating float64
+	CycleChange   int
+}
+
+// AverageChange is the cycle change per member.
+func (s FactionStanding) AverageChange() float64 {
+	if s.Members == 0 {
+		return 0
+	}
+	return float64(s.CycleChange) / float64(s.Members)
+}
+
+func (s *Store) CreateFaction(ctx context.Context, name, description string) (Faction, error) {
+	faction := Faction{Name: name, Description: description}
+	err := s.conn.QueryRow(ctx, `
+		INSERT INTO factions (name, description)
+		VALUES ($1, NULLIF($2, ''))
+		ON CONFLICT (name) DO NOTHING
+		RETURNING id
+	`, name, description).Scan(&faction.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Faction{}, ErrFactionExists
+	}
+	return faction, err
+}
+
+func (s *Store) ListFactions(ctx context.Context) ([]Faction, error) {
+	rows, err := s.conn.Query(ctx, `
+		SELECT f.id, f.name, COALESCE(f.description, ''), COUNT(p.id)
+		FROM factions f
+		LEFT JOIN players p ON p.faction_id = f.id
+		GROUP BY f.id
+		ORDER BY f.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Faction, error) {
+		var f Faction
+		err := row.Scan(&f.ID, &f.Name, &f.Description, &f.Members)
+		return f, err
+	})
+}
+
+// GetFactionByName looks a faction up ignoring case.
+func (s *Store) GetFactionByName(ctx context.Context, name string) (Faction, error) {
+	var f Faction
+	err := s.conn.QueryRow(ctx, `
+		SELECT f.id, f.name, COALESCE(f.description, ''),
+			(SELECT COUNT(*) FROM players WHERE faction_id = f.id)
+		FROM factions f
+		WHERE LOWER(f.name) = LOWER($1)
+	`, strings.TrimSpace(name)).Scan(&f.ID, &f.Name, &f.Description, &f.Members)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Faction{}, ErrFactionNotFound
+	}
+	return f, err
+}
+
+func (s *Store) GetFaction(ctx context.Context, factionID int) (Faction, error) {
+	var f Faction
+	err := s.conn.QueryRow(ctx, `
+		SELECT f.id, f.name, COALESCE(f.description, ''),
+			(SELECT COUNT(*) FROM players WHERE faction_id = f.id)
+		FROM factions f
+		WHERE f.id = $1
+	`, factionID).Scan(&f.ID, &f.Name, &f.Description, &f.Members)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Faction{}, ErrFactionNotFound
+	}
+	return f, err
+}
+
+// SetPlayerFaction moves the player into the faction; a nil factionID removes
+// the player from any faction.
+func (s *Store) SetPlayerFaction(ctx context.Context, playerID int, factionID *int) error {
+	tag, err := s.conn.Exec(ctx, `
+		UPDATE players SET faction_id = $1, updated_at = NOW() WHERE id = $2
+	`, factionID, playerID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPlayerNotFound
+	}
+	return nil
+}
+
+func (s *Store) UpdateFactionRules(ctx context.Context, rules FactionRules) error {
+	_, err := s.conn.Exec(ctx, `
+		UPDATE system_config
+		SET faction_forbid_own_likes = $1, faction_cross_weight = $2, version = version + 1, updated_at = NOW()
+		WHERE id = (SELECT id FROM system_config ORDER BY id DESC LIMIT 1)
+	`, rules.ForbidOwnLikes, rules.CrossWeight)
+	return err
+}
+
+// FactionLeaderboard ranks the factions by the rating their current members
+// gained in the given cycle, then by average rating.
+func (s *Store) FactionLeaderboard(ctx context.Context, cycleID int) ([]FactionStanding, error) {
+	rows, err := s.conn.Query(ctx, `
+		SELECT f.id, f.name, COUNT(p.id),
+			COALESCE(SUM(p.current_rating), 0),
+			COALESCE(AVG(p.current_rating), 0)::float8,
+			COALESCE(SUM(c.total), 0)
+		FROM factions f
+		LEFT JOIN players p ON p.faction_id = f.id
+		LEFT JOIN (
+			SELECT player_id, SUM(amount) AS total
+			FROM (
+				SELECT rated_id AS player_id, rating_value AS amount FROM player_ratings WHERE game_cycle_id = $1
+				UNION ALL
+				SELECT receiver_id, amount FROM rating_transfers WHERE game_cycle_id = $1
+				UNION ALL
+				SELECT sender_id, -amount FROM rating_transfers WHERE game_cycle_id = $1
+				UNION ALL
+				SELECT player_id, amount FROM rating_adjustments WHERE game_cycle_id = $1
+			) changes
+			GROUP BY player_id
+		) c ON c.player_id = p.id
+		GROUP BY f.id
+		ORDER BY 6 DESC, 5 DESC, f.name
+	`, cycleID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (FactionStanding, error) {
+		var st FactionStanding
+		err := row.Scan(&st.FactionID, &st.Name, &st.Members, &st.TotalRating, &st.AverageRating, &st.CycleChange)
+		return st, err
+	})
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"rts_for_rating_on_larp/internal/formula"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RatingFormula is a named, versioned rating formula. Saving a formula under
+// an existing name creates a new version; old versions stay referenced by the
+// cycles that used them.
+type RatingFormula struct {
+	ID          int
+	Name        string
+	Version     int
+	Strategy    string
+	Params      formula.Params
+	Description string
+	CreatedAt   time.Time
+}
+
+const formulaColumns = "id, name, version, strategy, params, COALESCE(description, ''), created_at"
+
+func (s *Store) SaveFormula(ctx context.Context, name, strategy string, params formula.Params, description string) (RatingFormula, error) {
+	if params == nil {
+		params = formula.Params{}
+	}
+	row := s.conn.QueryRow(ctx, `
+		INSERT INTO rating_formulas (name, version, strategy, params, description)
+		VALUES ($1, (SELECT COALESCE(MAX(version), 0) + 1 FROM rating_formulas WHERE name = $1), $2, $3, NULLIF($4, ''))
+		RETURNING `+formulaColumns, name, strategy, params, description)
+	return scanFormula(row)
+}
+
+// GetFormula returns the given version of a formula, or the latest one when
+// version is 0.
+func (s *Store) GetFormula(ctx context.Context, name string, version int) (RatingFormula, error) {
+	row := s.conn.QueryRow(ctx, `
+		SELECT `+formulaColumns+`
+		FROM rating_formulas
+		WHERE name = $1 AND ($2 = 0 OR version = $2)
+		ORDER BY version DESC
+		LIMIT 1
+	`, name, version)
+	return scanFormula(row)
+}
+
+// ListFormulas returns the latest version of every formula.
+func (s *Store) ListFormulas(ctx context.Context) ([]RatingFormula, error) {
+	rows, err := s.conn.Query(ctx, `
+		SELECT DISTINCT ON (name) `+formulaColumns+`
+		FROM rating_formulas
+		ORDER BY name, version DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (RatingFormula, error) {
+		return scanFormula(row)
+	})
+}
+
+// SetNextCycleFormula selects the formula that new cycles start with.
+func (s *Store) SetNextCycleFormula(ctx context.Context, formulaID int) error {
+	_, err := s.conn.Exec(ctx, `
+		UPDATE system_config
+		SET rating_formula_id = $1, version = version + 1, updated_at = NOW()
+		WHERE id = (SELECT id FROM system_config ORDER BY id DESC LIMIT 1)
+	`, formulaID)
+	return err
+}
+
+func (s *Store) SetCycleFormula(ctx context.Context, cycleID, formulaID int) error {
+	_, err := s.conn.Exec(ctx, `
+		UPDATE game_cycles SET rating_formula_id = $1, updated_at = NOW() WHERE id = $2
+	`, formulaID, cycleID)
+	return err
+}
+
+func (s *Store) GetNextCycleFormula(ctx context.Context) (RatingFormula, error) {
+	row := s.conn.QueryRow(ctx, `
+		SELECT `+formulaColumns+`
+		FROM rating_formulas
+		WHERE id = (SELECT rating_formula_id FROM system_config ORDER BY id DESC LIMIT 1)
+	`)
+	f, err := scanFormula(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s.legacyFormula(ctx)
+	}
+	return f, err
+}
+
+// GetCycleFormula returns the formula selected for a cycle. Cycles without a
+// formula use the level ratio with A and B from system_config.
+func (s *Store) GetCycleFormula(ctx context.Context, cycleID int) (RatingFormula, error) {
+	row := s.conn.QueryRow(ctx, `
+		SELECT `+formulaColumns+`
+		FROM rating_formulas
+		WHERE id = (SELECT rating_formula_id FROM game_cycles WHERE id = $1)
+	`, cycleID)
+	f, err := scanFormula(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s.legacyFormula(ctx)
+	}
+	return f, err
+}
+
+func (s *Store) legacyFormula(ctx context.Context) (RatingFormula, error) {
+	cfg, err := s.GetSystemConfig(ctx)
+	if err != nil {
+		return RatingFormula{}, err
+	}
+	return RatingFormula{
+		Name:     "system_config",
+		Strategy: formula.LevelRatio,
+		Params:   formula.Params{"a": cfg.RatingFormulaA, "b": cfg.RatingFormulaB},
+	}, nil
+}
+
+func scanFormula(row pgx.Row) (RatingFormula, error) {
+	var f RatingFormula
+	if err := row.Scan(&f.ID, &f.Name, &f.Version, &f.Strategy, &f.Params, &f.Description, &f.CreatedAt); err != nil {
+		return RatingFormula{}, err
+	}
+	return f, nil
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNoHistory is returned for a history page past the player's first
+// active cycle.
+var ErrNoHistory = errors.New("no history")
+
+const (
+	HistoryLike        = "like"
+	HistoryDislike     = "dislike"
+	HistoryTransferIn  = "transfer_in"
+	HistoryTransferOut = "transfer_out"
+	HistoryLevel       = "level"
+	HistoryAdjustment  = "adjustment"
+)
+
+// HistoryPage is one cycle of a player's statement. Page 0 is the latest
+// cycle with any activity of the player; Pages is the number of such cycles.
+type HistoryPage struct {
+	Page    int
+	Pages   int
+	Cycle   GameCycle
+	Summary HistorySummary
+	// Events are the latest events of the cycle, newest first.
+	Events []HistoryEvent
+}
+
+type HistorySummary struct {
+	Likes          int
+	LikesSum       int
+	Dislikes       int
+	DislikesSum    int
+	TransfersIn    int
+	AmountIn       int
+	TransfersOut   int
+	AmountOut      int
+	Adjustments    int
+	AdjustmentsSum int
+}
+
+// Total is the rating change of the cycle from ratings, transfers and manual
+// adjustments.
+func (s HistorySummary) Total() int {
+	return s.LikesSum + s.DislikesSum + s.AmountIn - s.AmountOut + s.AdjustmentsSum
+}
+
+// HistoryEvent is a single line of the statement. CounterpartID is the rater
+// or the other side of a transfer; it is nil for level changes, adjustments
+// and raters hidden by the anonymity policy. Reason is set for adjustments.
+type HistoryEvent struct {
+	Kind            string
+	Amount          int
+	CounterpartID   *int
+	CounterpartName string
+	OldLevel        *int
+	NewLevel        int
+	Reason          string
+	CreatedAt       time.Time
+}
+
+// PlayerHistory returns the given page of the player's statement as the
+// viewer may see it: raters hidden by the anonymity policy come back without
+// CounterpartID and name. At most eventLimit events are returned.
+func (s *Store) PlayerHistory(ctx context.Context, playerID, page, eventLimit int, viewer Viewer) (HistoryPage, error) {
+	tx, err := s.beginReadOnly(ctx)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	history := HistoryPage{Page: page}
+	var cycleID *int
+	err = tx.QueryRow(ctx, `
+		WITH active AS (
+			SELECT game_cycle_id FROM player_ratings WHERE rated_id = $1
+			UNION
+			SELECT game_cycle_id FROM rating_transfers WHERE $1 IN (sender_id, receiver_id)
+			UNION
+			SELECT game_cycle_id FROM player_level_history WHERE player_id = $1
+			UNION
+			SELECT game_cycle_id FROM rating_adjustments WHERE player_id = $1
+		)
+		SELECT
+			(SELECT COUNT(*) FROM active a JOIN game_cycles gc ON gc.id = a.game_cycle_id),
+			(SELECT gc.id FROM active a JOIN game_cycles gc ON gc.id = a.game_cycle_id
+			 ORDER BY gc.cycle_number DESC OFFSET $2 LIMIT 1)
+	`, playerID, page).Scan(&history.Pages, &cycleID)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	if cycleID == nil {
+		return history, ErrNoHistory
+	}
+
+	var cycleEnded bool
+	if err := tx.QueryRow(ctx, `
+		SELECT id, cycle_number, start_time, end_time, duration_minutes, rating_timeout_minutes, NOT is_active
+		FROM game_cycles WHERE id = $1
+	`, *cycleID).Scan(&history.Cycle.ID, &history.Cycle.CycleNumber, &history.Cycle.StartTime, &history.Cycle.EndTime,
+		&history.Cycle.DurationMinutes, &history.Cycle.RatingTimeoutMinutes, &cycleEnded); err != nil {
+		return HistoryPage{}, err
+	}
+	policy, err := raterVisibility(ctx, tx)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	sum := &history.Summary
+	if err := tx.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE rating_type = 'like'),
+			COALESCE(SUM(rating_value) FILTER (WHERE rating_type = 'like'), 0),
+			COUNT(*) FILTER (WHERE rating_type = 'dislike'),
+			COALESCE(SUM(rating_value) FILTER (WHERE rating_type = 'dislike'), 0)
+		FROM player_ratings
+		WHERE rated_id = $1 AND game_cycle_id = $2
+	`, playerID, *cycleID).Scan(&sum.Likes, &sum.LikesSum, &sum.Dislikes, &sum.DislikesSum); err != nil {
+		return HistoryPage{}, err
+	}
+	if err := tx.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE receiver_id = $1),
+			COALESCE(SUM(amount) FILTER (WHERE receiver_id = $1), 0),
+			COUNT(*) FILTER (WHERE sender_id = $1),
+			COALESCE(SUM(amount) FILTER (WHERE sender_id = $1), 0)
+		FROM rating_transfers
+		WHERE $1 IN (sender_id, receiver_id) AND game_cycle_id = $2
+	`, playerID, *cycleID).Scan(&sum.TransfersIn, &sum.AmountIn, &sum.TransfersOut, &sum.AmountOut); err != nil {
+		return HistoryPage{}, err
+	}
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(amount), 0)
+		FROM rating_adjustments
+		WHERE player_id = $1 AND game_cycle_id = $2
+	`, playerID, *cycleID).Scan(&sum.Adjustments, &sum.AdjustmentsSum); err != nil {
+		return HistoryPage{}, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT kind, amount, counterpart_id, COALESCE(cp.character_name, p.full_name, ''), old_level, new_level, reason, created_at
+		FROM (
+			SELECT rating_type AS kind, rating_value AS amount, rater_id AS counterpart_id,
+				NULL::int AS old_level, 0 AS new_level, '' AS reason, created_at
+			FROM player_ratings
+			WHERE rated_id = $1 AND game_cycle_id = $2
+			UNION ALL
+			SELECT CASE WHEN receiver_id = $1 THEN 'transfer_in' ELSE 'transfer_out' END, amount,
+				CASE WHEN receiver_id = $1 THEN sender_id ELSE receiver_id END, NULL, 0, '', created_at
+			FROM rating_transfers
+			WHERE $1 IN (sender_id, receiver_id) AND game_cycle_id = $2
+			UNION ALL
+			SELECT 'level', 0, NULL, old_level, new_level, '', created_at
+			FROM player_level_history
+			WHERE player_id = $1 AND game_cycle_id = $2
+			UNION ALL
+			SELECT 'adjustment', amount, NULL, NULL, 0, reason, created_at
+			FROM rating_adjustments
+			WHERE player_id = $1 AND game_cycle_id = $2
+		) events
+		LEFT JOIN players p ON p.id = events.counterpart_id
+		LEFT JOIN character_profiles cp ON cp.player_id = p.id AND cp.character_name <> ''
+		ORDER BY created_at DESC
+		LIMIT $3
+	`, playerID, *cycleID, eventLimit)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	history.Events, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (HistoryEvent, error) {
+		var e HistoryEvent
+		err := row.Scan(&e.Kind, &e.Amount, &e.CounterpartID, &e.CounterpartName, &e.OldLevel, &e.NewLevel, &e.Reason, &e.CreatedAt)
+		return e, err
+	})
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	for i, e := range history.Events {
+		isRating := e.Kind == HistoryLike || e.Kind == HistoryDislike
+		if isRating && e.CounterpartID != nil && !viewer.CanSeeRater(policy, *e.CounterpartID, cycleEnded) {
+			history.Events[i].CounterpartID, history.Events[i].CounterpartName = nil, ""
+		}
+	}
+	return history, tx.Commit(ctx)
+}
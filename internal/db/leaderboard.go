@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// LeaderboardEntry is a player's place in a ranking. Players with the same
+// rating share a place. Name is the character name when the player has one.
+type LeaderboardEntry struct {
+	Rank     int
+	PlayerID int
+	Name     string
+	Level    int
+	Rating   int
+}
+
+// Mover is a player whose rating changed the most through ratings received
+// in a cycle.
+type Mover struct {
+	PlayerID int
+	Name     string
+	Level    int
+	Change   int
+}
+
+// anonymousNameFormat labels a player without a character name in public
+// places; displayNameColumn is its SQL counterpart, so the real name never
+// shows up there.
+const (
+	anonymousNameFormat = "Игрок #%d"
+	displayNameColumn   = "COALESCE(NULLIF(cp.character_name, ''), 'Игрок #' || p.id)"
+)
+
+// TopPlayers returns the first limit players by rating. A non-zero level
+// restricts the ranking to the players of that level.
+func (s *Store) TopPlayers(ctx context.Context, limit, level int) ([]LeaderboardEntry, error) {
+	rows, err := s.conn.Query(ctx, `
+		SELECT RANK() OVER (ORDER BY p.current_rating DESC), p.id, `+displayNameColumn+`, p.current_level, p.current_rating
+		FROM players p
+		LEFT JOIN character_profiles cp ON cp.player_id = p.id
+		WHERE $2 = 0 OR p.current_level = $2
+		ORDER BY p.current_rating DESC, p.id
+		LIMIT $1
+	`, limit, level)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (LeaderboardEntry, error) {
+		var e LeaderboardEntry
+		err := row.Scan(&e.Rank, &e.PlayerID, &e.Name, &e.Level, &e.Rating)
+		return e, err
+	})
+}
+
+// PlayerRank returns the player's place in the same ranking as TopPlayers.
+func (s *Store) PlayerRank(ctx context.Context, playerID, level int) (LeaderboardEntry, error) {
+	var e LeaderboardEntry
+	err := s.conn.QueryRow(ctx, `
+		SELECT rank, id, name, current_level, current_rating
+		FROM (
+			SELECT RANK() OVER (ORDER BY p.current_rating DESC) AS rank, p.id, `+displayNameColumn+` AS name,
+				p.current_level, p.current_rating
+			FROM players p
+			LEFT JOIN character_profiles cp ON cp.player_id = p.id
+			WHERE $2 = 0 OR p.current_level = $2
+		) ranked
+		WHERE id = $1
+	`, playerID, level).Scan(&e.Rank, &e.PlayerID, &e.Name, &e.Level, &e.Rating)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return LeaderboardEntry{}, ErrPlayerNotFound
+	}
+	return e, err
+}
+
+// CycleMovers returns up to limit players who gained the most and up to limit
+// players who lost the most through ratings received in the cycle.
+func (s *Store) CycleMovers(ctx context.Context, cycleID, limit int) (gainers, losers []Mover, err error) {
+	rows, err := s.conn.Query(ctx, `
+		WITH changes AS (
+			SELECT rated_id, SUM(rating_value) AS change
+			FROM player_ratings
+			WHERE game_cycle_id = $1
+			GROUP BY rated_id
+		)
+		SELECT * FROM (
+			SELECT p.id, `+displayNameColumn+`, p.current_level, c.change
+			FROM changes c
+			JOIN players p ON p.id = c.rated_id
+			LEFT JOIN character_profiles cp ON cp.player_id = p.id
+			WHERE c.change > 0
+			ORDER BY c.change DESC, p.id
+			LIMIT $2
+		) up
+		UNION ALL
+		SELECT * FROM (
+			SELECT p.id, `+displayNameColumn+`, p.current_level, c.change
+			FROM changes c
+			JOIN players p ON p.id = c.rated_id
+			LEFT JOIN character_profiles cp ON cp.player_id = p.id
+			WHERE c.change < 0
+			ORDER BY c.change ASC, p.id
+			LIMIT $2
+		) down
+	`, cycleID, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	movers, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Mover, error) {
+		var m Mover
+		err := row.Scan(&m.PlayerID, &m.Name, &m.Level, &m.Change)
+		return m, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, m := range movers {
+		if m.Change > 0 {
+			gainers = append(gainers, m)
+		} else {
+			losers = append(losers, m)
+		}
+	}
+	return gainers, losers, nil
+}
+
+func (s *Store) UpdateLeaderboardVisibility(ctx context.Context, hideNumbers bool) error {
+	_, err := s.conn.Exec(ctx, `
+		UPDATE system_config
+		SET leaderboard_hide_numbers = $1, updated_at = NOW()
+		WHERE id = (SELECT id FROM system_config ORDER BY id DESC LIMIT 1)
+	`, hideNumbers)
+	return err
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RatingDrift compares a player's stored rating with the rating replayed from
+// the ledger: the initial rating plus received ratings, net transfers and
+// manual adjustments.
+type RatingDrift struct {
+	PlayerID     int
+	FullName     string
+	Stored       int
+	Expected     int
+	Ratings      int
+	TransfersIn  int
+	TransfersOut int
+	Adjustments  int
+}
+
+func (d RatingDrift) Delta() int { return d.Stored - d.Expected }
+
+// CheckLedger replays every player's rating and returns the players whose
+// stored rating differs from it. With repair set, the drifted ratings are
+// reset to the replayed value and each correction is logged as a
+// system_event, all in one transaction with the players locked.
+func (s *Store) CheckLedger(ctx context.Context, repair bool) ([]RatingDrift, error) {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if repair {
+		if _, err := tx.Exec(ctx, `SELECT id FROM players ORDER BY id FOR UPDATE`); err != nil {
+			return nil, err
+		}
+	}
+	drifts, err := ledgerDrifts(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if !repair || len(drifts) == 0 {
+		return drifts, tx.Commit(ctx)
+	}
+
+	for _, drift := range drifts {
+		player, err := scanPlayer(tx.QueryRow(ctx, `SELECT `+playerColumns+` FROM players WHERE id = $1`, drift.PlayerID))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE players SET current_rating = $1, updated_at = NOW() WHERE id = $2
+		`, drift.Expected, drift.PlayerID); err != nil {
+			return nil, err
+		}
+		change := -drift.Delta()
+		if _, err := logOperation(ctx, tx, operationEntry{
+			Type:         "system_event",
+			Target:       snapshot(player, change),
+			RatingChange: &change,
+			Details: map[string]any{
+				"event":         "ledger_repair",
+				"stored":        drift.Stored,
+				"expected":      drift.Expected,
+				"ratings":       drift.Ratings,
+				"transfers_in":  drift.TransfersIn,
+				"transfers_out": drift.TransfersOut,
+				"adjustments":   drift.Adjustments,
+			},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return drifts, tx.Commit(ctx)
+}
+
+func ledgerDrifts(ctx context.Context, q querier) ([]RatingDrift, error) {
+	rows, err := q.Query(ctx, `
+		SELECT p.id, p.full_name, p.current_rating,
+			COALESCE(r.total, 0), COALESCE(tin.total, 0), COALESCE(tout.total, 0), COALESCE(a.total, 0)
+		FROM players p
+		LEFT JOIN (
+			SELECT rated_id AS player_id, SUM(rating_value) AS total FROM player_ratings GROUP BY rated_id
+		) r ON r.player_id = p.id
+		LEFT JOIN (
+			SELECT receiver_id AS player_id, SUM(amount) AS total FROM rating_transfers GROUP BY receiver_id
+		) tin ON tin.player_id = p.id
+		LEFT JOIN (
+			SELECT sender_id AS player_id, SUM(amount) AS total FROM rating_transfers GROUP BY sender_id
+		) tout ON tout.player_id = p.id
+		LEFT JOIN (
+			SELECT player_id, SUM(amount) AS total FROM rating_adjustments GROUP BY player_id
+		) a ON a.player_id = p.id
+		ORDER BY p.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	all, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (RatingDrift, error) {
+		var d RatingDrift
+		err := row.Scan(&d.PlayerID, &d.FullName, &d.Stored, &d.Ratings, &d.TransfersIn, &d.TransfersOut, &d.Adjustments)
+		d.Expected = InitialRating + d.Ratings + d.TransfersIn - d.TransfersOut + d.Adjustments
+		return d, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	drifts := all[:0]
+	for _, d := range all {
+		if d.Delta() != 0 {
+			drifts = append(drifts, d)
+		}
+	}
+	return drifts, nil
+}
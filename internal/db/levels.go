@@ -0,0 +1,458 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const MaxLevel = 5
+
+var ErrNoPlayers = errors.New("no players to distribute")
+
+type LevelBoundary struct {
+	Level            int
+	MinRating        int
+	MaxRating        int
+	TargetPercentMin int
+	TargetPercentMax int
+	ActualPercentage float64
+	PlayerCount      int
+}
+
+// UnboundedBelow and UnboundedAbove report whether the range is open on that
+// side, which is how the lowest and the highest levels of a distribution are
+// stored.
+func (b LevelBoundary) UnboundedBelow() bool { return b.MinRating == math.MinInt32 }
+func (b LevelBoundary) UnboundedAbove() bool { return b.MaxRating == math.MaxInt32 }
+
+// SetLevelBoundary sets explicit rating cut-offs for a level. It switches the
+// level back to manual mode by clearing its target percentages.
+func (s *Store) SetLevelBoundary(ctx context.Context, cycleID, level, minRating, maxRating int) error {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO level_boundaries (game_cycle_id, level_number, min_rating, max_rating, target_percentage_min, target_percentage_max)
+		VALUES ($1, $2, $3, $4, 0, 0)
+		ON CONFLICT (game_cycle_id, level_number) DO UPDATE
+		SET min_rating = EXCLUDED.min_rating, max_rating = EXCLUDED.max_rating,
+			target_percentage_min = 0, target_percentage_max = 0, updated_at = NOW()
+	`, cycleID, level, minRating, maxRating); err != nil {
+		return err
+	}
+	if err := refreshLevelStats(ctx, tx, cycleID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ApplyLevelDistribution derives rating cut-offs from the current rating
+// distribution so that level i holds roughly shares[i-1] percent of players.
+// Shares are ordered from the lowest level up and must add up to 100.
+func (s *Store) ApplyLevelDistribution(ctx context.Context, cycleID int, shares []int) ([]LevelBoundary, error) {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := applyLevelDistribution(ctx, tx, cycleID, shares); err != nil {
+		return nil, err
+	}
+	boundaries, err := listLevelBoundaries(ctx, tx, cycleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return boundaries, nil
+}
+
+func (s *Store) ListLevelBoundaries(ctx context.Context, cycleID int) ([]LevelBoundary, error) {
+	return listLevelBoundaries(ctx, s.conn, cycleID)
+}
+
+func ValidateLevelShares(shares []int) error {
+	if len(shares) == 0 || len(shares) > MaxLevel {
+		return fmt.Errorf("expected 1..%d shares, got %d", MaxLevel, len(shares))
+	}
+	total := 0
+	for _, share := range shares {
+		if share <= 0 {
+			return errors.New("shares must be positive")
+		}
+		total += share
+	}
+	if total != 100 {
+		return fmt.Errorf("shares must add up to 100, got %d", total)
+	}
+	return nil
+}
+
+func applyLevelDistribution(ctx context.Context, q querier, cycleID int, shares []int) error {
+	if err := ValidateLevelShares(shares); err != nil {
+		return err
+	}
+	rows, err := q.Query(ctx, `
+		SELECT current_rating FROM players ORDER BY current_rating
+	`)
+	if err != nil {
+		return err
+	}
+	ratings, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+	if len(ratings) == 0 {
+		return ErrNoPlayers
+	}
+
+	if _, err := q.Exec(ctx, `
+		DELETE FROM level_boundaries WHERE game_cycle_id = $1 AND level_number > $2
+	`, cycleID, len(shares)); err != nil {
+		return err
+	}
+	for _, b := range distributeLevels(ratings, shares) {
+		if _, err := q.Exec(ctx, `
+			INSERT INTO level_boundaries (game_cycle_id, level_number, min_rating, max_rating, target_percentage_min, target_percentage_max)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (game_cycle_id, level_number) DO UPDATE
+			SET min_rating = EXCLUDED.min_rating, max_rating = EXCLUDED.max_rating,
+				target_percentage_min = EXCLUDED.target_percentage_min,
+				target_percentage_max = EXCLUDED.target_percentage_max,
+				updated_at = NOW()
+		`, cycleID, b.Level, b.MinRating, b.MaxRating, b.TargetPercentMin, b.TargetPercentMax); err != nil {
+			return err
+		}
+	}
+	return refreshLevelStats(ctx, q, cycleID)
+}
+
+// distributeLevels turns percentile shares into contiguous rating ranges over
+// ratings sorted ascending. Players with equal ratings always land on the same
+// level, so with many ties a level may end up empty (max below min).
+func distributeLevels(ratings []int, shares []int) []LevelBoundary {
+	n := len(ratings)
+	boundaries := make([]LevelBoundary, len(shares))
+	cumulative := 0
+	for i, share := range shares {
+		b := LevelBoundary{Level: i + 1, TargetPercentMin: cumulative, TargetPercentMax: cumulative + share}
+		if i == 0 {
+			b.MinRating = math.MinInt32
+		} else {
+			idx := int(math.Round(float64(cumulative) * float64(n) / 100))
+			if idx < n {
+				b.MinRating = ratings[idx]
+			} else {
+				b.MinRating = ratings[n-1] + 1
+			}
+			if b.MinRating < boundaries[i-1].MinRating {
+				b.MinRating = boundaries[i-1].MinRating
+			}
+			boundaries[i-1].MaxRating = b.MinRating - 1
+		}
+		b.MaxRating = math.MaxInt32
+		boundaries[i] = b
+		cumulative += share
+	}
+	return boundaries
+}
+
+func refreshLevelStats(ctx context.Context, q querier, cycleID int) error {
+	_, err := q.Exec(ctx, `
+		WITH total AS (SELECT COUNT(*) AS n FROM players),
+		counts AS (
+			SELECT lb.id, COUNT(p.id) AS cnt
+			FROM level_boundaries lb
+			LEFT JOIN players p ON p.current_rating BETWEEN lb.min_rating AND lb.max_rating
+			WHERE lb.game_cycle_id = $1
+			GROUP BY lb.id
+		)
+		UPDATE level_boundaries lb
+		SET player_count = counts.cnt,
+			actual_percentage = CASE WHEN total.n = 0 THEN 0 ELSE ROUND(counts.cnt * 100.0 / total.n, 2) END,
+			updated_at = NOW()
+		FROM counts, total
+		WHERE lb.id = counts.id
+	`, cycleID)
+	return err
+}
+
+func listLevelBoundaries(ctx context.Context, q querier, cycleID int) ([]LevelBoundary, error) {
+	rows, err := q.Query(ctx, `
+		SELECT level_number, min_rating, max_rating, target_percentage_min, target_percentage_max,
+			COALESCE(actual_percentage, 0)::float8, COALESCE(player_count, 0)
+		FROM level_boundaries
+		WHERE game_cycle_id = $1
+		ORDER BY level_number
+	`, cycleID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (LevelBoundary, error) {
+		var b LevelBoundary
+		err := row.Scan(&b.Level, &b.MinRating, &b.MaxRating, &b.TargetPercentMin, &b.TargetPercentMax, &b.ActualPercentage, &b.PlayerCount)
+		return b, err
+	})
+}
+
+// distributionShares returns the target shares when every level of the cycle
+// is in distribution mode, or nil when at least one level is set manually.
+func distributionShares(boundaries []LevelBoundary) []int {
+	if len(boundaries) == 0 {
+		return nil
+	}
+	shares := make([]int, 0, len(boundaries))
+	for i, b := range boundaries {
+		if b.Level != i+1 || b.TargetPercentMax == 0 {
+			return nil
+		}
+		shares = append(shares, b.TargetPercentMax-b.TargetPercentMin)
+	}
+	return shares
+}
+
+func (s *Store) GetLevelBoundaries(ctx context.Context, cycleID int) (map[int][2]int, error) {
+	return getLevelBoundaries(ctx, s.conn, cycleID)
+}
+
+// RecalculateLevels applies boundaries to every player immediately. It is the
+// manual, mid-cycle variant and does not mark the cycle as recalculated: the
+// end-of-cycle recalculation still runs when the cycle closes. initiatorID is
+// the admin who started it, or 0 when unknown.
+func (s *Store) RecalculateLevels(ctx context.Context, cycleID int, boundaries map[int][2]int, initiatorID int) error {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var initiator *playerSnapshot
+	if initiatorID != 0 {
+		if initiator, err = playerSnapshotByID(ctx, tx, initiatorID); err != nil {
+			return err
+		}
+	}
+	if err := recalculateLevels(ctx, tx, cycleID, boundaries, initiator, levelTriggerManual); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func getLevelBoundaries(ctx context.Context, q querier, cycleID int) (map[int][2]int, error) {
+	rows, err := q.Query(ctx, `
+		SELECT level_number, min_rating, max_rating
+		FROM level_boundaries
+		WHERE game_cycle_id = $1
+	`, cycleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	boundaries := make(map[int][2]int)
+	for rows.Next() {
+		var level, min, max int
+		if err := rows.Scan(&level, &min, &max); err != nil {
+			return nil, err
+		}
+		boundaries[level] = [2]int{min, max}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return boundaries, nil
+}
+
+// copyLevelBoundaries copies the boundaries of the most recent cycle before
+// toCycleID that has any into toCycleID, keeping boundaries already set there.
+func copyLevelBoundaries(ctx context.Context, q querier, toCycleID int) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO level_boundaries (game_cycle_id, level_number, min_rating, max_rating, target_percentage_min, target_percentage_max)
+		SELECT $1, lb.level_number, lb.min_rating, lb.max_rating, lb.target_percentage_min, lb.target_percentage_max
+		FROM level_boundaries lb
+		WHERE lb.game_cycle_id = (
+			SELECT gc.id
+			FROM game_cycles gc
+			JOIN level_boundaries src ON src.game_cycle_id = gc.id
+			WHERE gc.cycle_number < (SELECT cycle_number FROM game_cycles WHERE id = $1)
+			ORDER BY gc.cycle_number DESC
+			LIMIT 1
+		)
+		ON CONFLICT (game_cycle_id, level_number) DO NOTHING
+	`, toCycleID)
+	return err
+}
+
+// Triggers of a level_change, stored in its details.
+const (
+	levelTriggerCycleEnd = "cycle_end"
+	levelTriggerManual   = "manual"
+)
+
+// recalculateLevels moves players whose rating left their level's range and
+// logs a level_change with the given trigger for each of them; initiator is
+// nil for the automatic end-of-cycle run and for the web admin.
+func recalculateLevels(ctx context.Context, q querier, cycleID int, boundaries map[int][2]int, initiator *playerSnapshot, trigger string) error {
+	type playerLevel struct {
+		id     int
+		level  int
+		rating int
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT id, current_level, current_rating
+		FROM players
+		ORDER BY id
+		FOR UPDATE
+	`)
+	if err != nil {
+		return err
+	}
+	players, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (playerLevel, error) {
+		var p playerLevel
+		err := row.Scan(&p.id, &p.level, &p.rating)
+		return p, err
+	})
+	if err != nil {
+		return err
+	}
+
+	levels := make([]int, 0, len(boundaries))
+	for level := range boundaries {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+
+	for _, p := range players {
+		newLevel := p.level
+		for _, level := range levels {
+			bounds := boundaries[level]
+			if p.rating >= bounds[0] && p.rating <= bounds[1] {
+				newLevel = level
+				break
+			}
+		}
+		if newLevel == p.level {
+			continue
+		}
+		if _, err := q.Exec(ctx, `
+			UPDATE players SET current_level = $1, updated_at = NOW() WHERE id = $2
+		`, newLevel, p.id); err != nil {
+			return err
+		}
+		if _, err := q.Exec(ctx, `
+			INSERT INTO player_level_history (player_id, old_level, new_level, old_rating, new_rating, game_cycle_id)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, p.id, p.level, newLevel, p.rating, p.rating, cycleID); err != nil {
+			return err
+		}
+		if _, err := logOperation(ctx, q, operationEntry{
+			Type:      "level_change",
+			CycleID:   &cycleID,
+			Initiator: initiator,
+			Target:    &playerSnapshot{ID: p.id, Level: newLevel, RatingBefore: p.rating, RatingAfter: p.rating},
+			Details: map[string]any{
+				"old_level": p.level,
+				"new_level": newLevel,
+				"trigger":   trigger,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recalculateClosedCycles runs the end-of-cycle level recalculation for every
+// closed cycle that has not been recalculated yet, oldest first. A cycle is
+// marked done in the same transaction as its level changes, so a crash
+// leaves it pending and it is retried on the next call.
+func recalculateClosedCycles(ctx context.Context, q beginner) error {
+	rows, err := q.Query(ctx, `
+		SELECT id
+		FROM game_cycles
+		WHERE is_active = FALSE AND level_recalculation_done = FALSE
+		ORDER BY cycle_number
+	`)
+	if err != nil {
+		return err
+	}
+	cycleIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+	for _, cycleID := range cycleIDs {
+		if err := recalculateClosedCycle(ctx, q, cycleID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recalculateClosedCycle(ctx context.Context, q beginner, cycleID int) error {
+	tx, err := q.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var done bool
+	err = tx.QueryRow(ctx, `
+		SELECT level_recalculation_done FROM game_cycles WHERE id = $1 FOR UPDATE
+	`, cycleID).Scan(&done)
+	if errors.Is(err, pgx.ErrNoRows) || done {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	listed, err := listLevelBoundaries(ctx, tx, cycleID)
+	if err != nil {
+		return err
+	}
+	if len(listed) == 0 {
+		if err := copyLevelBoundaries(ctx, tx, cycleID); err != nil {
+			return err
+		}
+		if listed, err = listLevelBoundaries(ctx, tx, cycleID); err != nil {
+			return err
+		}
+	}
+	// In distribution mode the cut-offs follow the ratings at the end of the
+	// cycle, not the ones the boundaries were last derived from.
+	if shares := distributionShares(listed); shares != nil {
+		if err := applyLevelDistribution(ctx, tx, cycleID, shares); err != nil && !errors.Is(err, ErrNoPlayers) {
+			return err
+		}
+	}
+	boundaries, err := getLevelBoundaries(ctx, tx, cycleID)
+	if err != nil {
+		return err
+	}
+	if len(boundaries) > 0 {
+		if err := recalculateLevels(ctx, tx, cycleID, boundaries, nil, levelTriggerCycleEnd); err != nil {
+			return err
+		}
+		if err := refreshLevelStats(ctx, tx, cycleID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE game_cycles SET level_recalculation_done = TRUE, updated_at = NOW() WHERE id = $1
+	`, cycleID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UpsertRatingLimit changes the template that is snapshotted into
+// cycle_rating_limits when the next cycle starts. The running cycle keeps its
+// own limits; use UpsertCycleRatingLimit to change those.
+func (s *Store) UpsertRatingLimit(ctx context.Context, level int, limit int) error {
+	_, err := s.conn.Exec(ctx, `
+		INSERT INTO system_rating_limits (player_level, ratings_per_cycle)
+		VALUES ($1, $2)
+		ON CONFLICT (player_level) DO UPDATE
+		SET ratings_per_cycle = EXCLUDED.ratings_per_cycle, updated_at = NOW()
+	`, level, limit)
+	return err
+}
+
+// UpsertCycleRatingLimit changes the limit of one cycle. When the cycle is the
+// active one, budgets of players on that level move by the difference between
+// the old and the new limit, so ratings already given stay spent.
+func (s *Store) UpsertCycleRatingLimit(ctx context.Context, cycleID, level, limit int) error {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var previous *int
+	err = tx.QueryRow(ctx, `
+		SELECT ratings_per_cycle
+		FROM cycle_rating_limits
+		WHERE game_cycle_id = $1 AND player_level = $2
+		FOR UPDATE
+	`, cycleID, level).Scan(&previous)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO cycle_rating_limits (game_cycle_id, player_level, ratings_per_cycle)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (game_cycle_id, player_level) DO UPDATE
+		SET ratings_per_cycle = EXCLUDED.ratings_per_cycle, updated_at = NOW()
+	`, cycleID, level, limit); err != nil {
+		return err
+	}
+
+	if previous != nil {
+		_, err = tx.Exec(ctx, `
+			UPDATE players
+			SET ratings_available = GREATEST(COALESCE(ratings_available, 0) + $3, 0), updated_at = NOW()
+			WHERE current_level = $2
+				AND EXISTS (SELECT 1 FROM game_cycles WHERE id = $1 AND is_active = TRUE)
+		`, cycleID, level, limit-*previous)
+	} else {
+		_, err = tx.Exec(ctx, `
+			UPDATE players p
+			SET ratings_available = GREATEST($3 - (
+				SELECT COUNT(*) FROM player_ratings pr WHERE pr.rater_id = p.id AND pr.game_cycle_id = $1
+			), 0), updated_at = NOW()
+			WHERE p.current_level = $2
+				AND EXISTS (SELECT 1 FROM game_cycles WHERE id = $1 AND is_active = TRUE)
+		`, cycleID, level, limit)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RefundRatings gives a player back count ratings in the current cycle.
+// Players without a limit are left untouched.
+func (s *Store) RefundRatings(ctx context.Context, playerID, count int) (Player, error) {
+	row := s.conn.QueryRow(ctx, `
+		UPDATE players
+		SET ratings_available = ratings_available + $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+playerColumns, playerID, count)
+	return scanPlayer(row)
+}
+
+func (s *Store) GetRatingLimit(ctx context.Context, cycleID, level int) (RatingLimit, error) {
+	var limit RatingLimit
+	row := s.conn.QueryRow(ctx, `
+		SELECT player_level, ratings_per_cycle
+		FROM cycle_rating_limits
+		WHERE game_cycle_id = $1 AND player_level = $2
+	`, cycleID, level)
+	if err := row.Scan(&limit.Level, &limit.Limit); err != nil {
+		return RatingLimit{}, err
+	}
+	return limit, nil
+}
+
+func (s *Store) ListCycleRatingLimits(ctx context.Context, cycleID int) ([]RatingLimit, error) {
+	rows, err := s.conn.Query(ctx, `
+		SELECT player_level, ratings_per_cycle
+		FROM cycle_rating_limits
+		WHERE game_cycle_id = $1
+		ORDER BY player_level
+	`, cycleID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanRatingLimit)
+}
+
+func (s *Store) ListSystemRatingLimits(ctx context.Context) ([]RatingLimit, error) {
+	rows, err := s.conn.Query(ctx, `
+		SELECT player_level, ratings_per_cycle
+		FROM system_rating_limits
+		ORDER BY player_level
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanRatingLimit)
+}
+
+func snapshotRatingLimits(ctx context.Context, q querier, cycleID int) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO cycle_rating_limits (game_cycle_id, player_level, ratings_per_cycle)
+		SELECT $1, player_level, ratings_per_cycle
+		FROM system_rating_limits
+		ON CONFLICT (game_cycle_id, player_level) DO NOTHING
+	`, cycleID)
+	return err
+}
+
+// resetRatingBudgets sets every player's budget to the limit of their level in
+// the given cycle, or to unlimited when the level has no limit.
+func resetRatingBudgets(ctx context.Context, q querier, cycleID int) error {
+	_, err := q.Exec(ctx, `
+		UPDATE players p
+		SET ratings_available = (
+			SELECT crl.ratings_per_cycle
+			FROM cycle_rating_limits crl
+			WHERE crl.game_cycle_id = $1 AND crl.player_level = p.current_level
+		), updated_at = NOW()
+	`, cycleID)
+	return err
+}
+
+func scanRatingLimit(row pgx.CollectableRow) (RatingLimit, error) {
+	var limit RatingLimit
+	err := row.Scan(&limit.Level, &limit.Limit)
+	return limit, err
+}
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_game_cycles_pending_recalc;
@@ -0,0 +1,7 @@
+-- Cycles closed before automatic recalculation existed must not be replayed.
+UPDATE game_cycles
+SET level_recalculation_done = TRUE
+WHERE is_active = FALSE;
+
+CREATE INDEX idx_game_cycles_pending_recalc ON game_cycles(cycle_number)
+WHERE is_active = FALSE AND level_recalculation_done = FALSE;
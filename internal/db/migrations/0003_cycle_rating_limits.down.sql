@@ -0,0 +1,2 @@
+-- Snapshotted limits are kept: cycle_rating_limits belongs to 0001_init.
+SELECT 1;
@@ -0,0 +1,8 @@
+-- Limits used to be read from system_rating_limits directly; give the cycle
+-- that is running during the upgrade the same limits it had before.
+INSERT INTO cycle_rating_limits (game_cycle_id, player_level, ratings_per_cycle)
+SELECT gc.id, srl.player_level, srl.ratings_per_cycle
+FROM game_cycles gc
+CROSS JOIN system_rating_limits srl
+WHERE gc.is_active = TRUE
+ON CONFLICT (game_cycle_id, player_level) DO NOTHING;
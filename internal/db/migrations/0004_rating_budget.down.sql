@@ -0,0 +1,3 @@
+ALTER TABLE players DROP CONSTRAINT IF EXISTS players_ratings_available_check;
+UPDATE players SET ratings_available = 20 WHERE ratings_available IS NULL;
+ALTER TABLE players ALTER COLUMN ratings_available SET DEFAULT 20;
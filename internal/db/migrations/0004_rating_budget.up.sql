@@ -0,0 +1,18 @@
+-- NULL means "no limit for the player's level"; the budget is set from
+-- cycle_rating_limits when a cycle starts.
+ALTER TABLE players ALTER COLUMN ratings_available DROP DEFAULT;
+
+UPDATE players p
+SET ratings_available = (
+    SELECT GREATEST(crl.ratings_per_cycle - (
+        SELECT COUNT(*) FROM player_ratings pr
+        WHERE pr.rater_id = p.id AND pr.game_cycle_id = gc.id
+    ), 0)
+    FROM game_cycles gc
+    JOIN cycle_rating_limits crl ON crl.game_cycle_id = gc.id AND crl.player_level = p.current_level
+    WHERE gc.is_active = TRUE
+    ORDER BY gc.start_time DESC
+    LIMIT 1
+);
+
+ALTER TABLE players ADD CONSTRAINT players_ratings_available_check CHECK (ratings_available >= 0);
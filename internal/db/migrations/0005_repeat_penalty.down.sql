@@ -0,0 +1,4 @@
+ALTER TABLE system_config
+    DROP COLUMN IF EXISTS repeat_penalty_window_minutes,
+    DROP COLUMN IF EXISTS repeat_penalty_min,
+    DROP COLUMN IF EXISTS repeat_penalty_step;
@@ -0,0 +1,4 @@
+ALTER TABLE system_config
+    ADD COLUMN repeat_penalty_step NUMERIC(3,2) NOT NULL DEFAULT 0 CHECK (repeat_penalty_step BETWEEN 0 AND 1),
+    ADD COLUMN repeat_penalty_min NUMERIC(3,2) NOT NULL DEFAULT 0.25 CHECK (repeat_penalty_min BETWEEN 0 AND 1),
+    ADD COLUMN repeat_penalty_window_minutes INTEGER NOT NULL DEFAULT 0 CHECK (repeat_penalty_window_minutes >= 0);
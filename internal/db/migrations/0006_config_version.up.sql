@@ -0,0 +1 @@
+ALTER TABLE system_config ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
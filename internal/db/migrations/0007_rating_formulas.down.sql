@@ -0,0 +1,3 @@
+ALTER TABLE game_cycles DROP COLUMN IF EXISTS rating_formula_id;
+ALTER TABLE system_config DROP COLUMN IF EXISTS rating_formula_id;
+DROP TABLE IF EXISTS rating_formulas;
@@ -0,0 +1,29 @@
+CREATE TABLE rating_formulas (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    version INTEGER NOT NULL CHECK (version > 0),
+    strategy VARCHAR(50) NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}'::jsonb,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(name, version)
+);
+
+CREATE INDEX idx_rating_formulas_name_version ON rating_formulas(name, version DESC);
+
+ALTER TABLE system_config ADD COLUMN rating_formula_id INTEGER REFERENCES rating_formulas(id);
+ALTER TABLE game_cycles ADD COLUMN rating_formula_id INTEGER REFERENCES rating_formulas(id);
+
+-- The formula that used to be hard-coded becomes version 1 of "default".
+INSERT INTO rating_formulas (name, version, strategy, params, description)
+SELECT 'default', 1, 'level_ratio',
+       jsonb_build_object('a', rating_formula_a, 'b', rating_formula_b),
+       'Исходная формула z·(A·уровень)/(уровень·B)'
+FROM system_config
+ORDER BY id DESC
+LIMIT 1;
+
+UPDATE system_config SET rating_formula_id = (SELECT id FROM rating_formulas WHERE name = 'default' AND version = 1);
+UPDATE game_cycles SET rating_formula_id = (SELECT id FROM rating_formulas WHERE name = 'default' AND version = 1)
+WHERE is_active = TRUE;
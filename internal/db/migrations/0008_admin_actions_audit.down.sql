@@ -0,0 +1,18 @@
+DROP INDEX IF EXISTS idx_admin_actions_created;
+
+DELETE FROM admin_actions WHERE admin_id IS NULL OR action_type IN ('refund_ratings', 'set_level_boundaries', 'change_rating_formula');
+
+ALTER TABLE admin_actions DROP CONSTRAINT IF EXISTS admin_actions_action_type_check;
+ALTER TABLE admin_actions ADD CONSTRAINT admin_actions_action_type_check CHECK (action_type IN (
+    'create_player',
+    'adjust_rating',
+    'change_cycle_settings',
+    'create_admin',
+    'change_player_role',
+    'regenerate_qr',
+    'force_level_recalc',
+    'set_rating_limits'
+));
+
+ALTER TABLE admin_actions DROP COLUMN IF EXISTS source;
+ALTER TABLE admin_actions ALTER COLUMN admin_id SET NOT NULL;
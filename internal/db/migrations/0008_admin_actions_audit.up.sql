@@ -0,0 +1,19 @@
+ALTER TABLE admin_actions ALTER COLUMN admin_id DROP NOT NULL;
+ALTER TABLE admin_actions ADD COLUMN source VARCHAR(10) NOT NULL DEFAULT 'bot' CHECK (source IN ('bot', 'web'));
+
+ALTER TABLE admin_actions DROP CONSTRAINT IF EXISTS admin_actions_action_type_check;
+ALTER TABLE admin_actions ADD CONSTRAINT admin_actions_action_type_check CHECK (action_type IN (
+    'create_player',
+    'adjust_rating',
+    'change_cycle_settings',
+    'create_admin',
+    'change_player_role',
+    'regenerate_qr',
+    'force_level_recalc',
+    'set_rating_limits',
+    'refund_ratings',
+    'set_level_boundaries',
+    'change_rating_formula'
+));
+
+CREATE INDEX idx_admin_actions_created ON admin_actions(created_at DESC);
@@ -0,0 +1,17 @@
+UPDATE admin_actions SET operation_log_id = NULL
+WHERE operation_log_id IN (SELECT id FROM operations_log WHERE operation_type = 'rating_adjustment');
+DELETE FROM operations_log WHERE operation_type = 'rating_adjustment';
+ALTER TABLE operations_log DROP CONSTRAINT IF EXISTS operations_log_operation_type_check;
+ALTER TABLE operations_log ADD CONSTRAINT operations_log_operation_type_check CHECK (operation_type IN (
+    'rating_like',
+    'rating_dislike',
+    'rating_transfer',
+    'player_creation',
+    'admin_action',
+    'level_change',
+    'cycle_start',
+    'cycle_end',
+    'system_event'
+));
+
+DROP TABLE IF EXISTS rating_adjustments;
@@ -0,0 +1,26 @@
+CREATE TABLE rating_adjustments (
+    id BIGSERIAL PRIMARY KEY,
+    player_id INTEGER NOT NULL REFERENCES players(id) ON DELETE CASCADE,
+    admin_id INTEGER REFERENCES players(id) ON DELETE SET NULL,
+    amount INTEGER NOT NULL CHECK (amount <> 0),
+    reason TEXT NOT NULL CHECK (reason <> ''),
+    game_cycle_id INTEGER REFERENCES game_cycles(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_rating_adjustments_player ON rating_adjustments(player_id, created_at DESC);
+
+ALTER TABLE operations_log DROP CONSTRAINT IF EXISTS operations_log_operation_type_check;
+ALTER TABLE operations_log ADD CONSTRAINT operations_log_operation_type_check CHECK (operation_type IN (
+    'rating_like',
+    'rating_dislike',
+    'rating_transfer',
+    'rating_adjustment',
+    'player_creation',
+    'admin_action',
+    'level_change',
+    'cycle_start',
+    'cycle_end',
+    'system_event'
+));
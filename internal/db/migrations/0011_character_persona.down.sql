@@ -0,0 +1,5 @@
+ALTER TABLE character_profiles
+    DROP COLUMN IF EXISTS photo_file_id,
+    DROP COLUMN IF EXISTS bio,
+    DROP COLUMN IF EXISTS faction,
+    DROP COLUMN IF EXISTS character_name;
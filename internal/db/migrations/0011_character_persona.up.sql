@@ -0,0 +1,12 @@
+ALTER TABLE character_profiles
+    ADD COLUMN character_name VARCHAR(255),
+    ADD COLUMN faction VARCHAR(100),
+    ADD COLUMN bio TEXT,
+    ADD COLUMN photo_file_id VARCHAR(255);
+
+-- /register used to write the character name into players.full_name, so
+-- everyone who already registered keeps it as the character name.
+UPDATE character_profiles cp
+SET character_name = p.full_name
+FROM players p
+WHERE p.id = cp.player_id AND cp.character_name IS NULL;
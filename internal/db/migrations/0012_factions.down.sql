@@ -0,0 +1,31 @@
+DELETE FROM admin_actions WHERE action_type IN ('create_faction', 'set_player_faction');
+
+ALTER TABLE admin_actions DROP CONSTRAINT IF EXISTS admin_actions_action_type_check;
+ALTER TABLE admin_actions ADD CONSTRAINT admin_actions_action_type_check CHECK (action_type IN (
+    'create_player',
+    'adjust_rating',
+    'change_cycle_settings',
+    'create_admin',
+    'change_player_role',
+    'regenerate_qr',
+    'force_level_recalc',
+    'set_rating_limits',
+    'refund_ratings',
+    'set_level_boundaries',
+    'change_rating_formula'
+));
+
+ALTER TABLE system_config
+    DROP COLUMN IF EXISTS faction_cross_weight,
+    DROP COLUMN IF EXISTS faction_forbid_own_likes;
+
+ALTER TABLE character_profiles ADD COLUMN faction VARCHAR(100);
+UPDATE character_profiles cp
+SET faction = f.name
+FROM players p
+JOIN factions f ON f.id = p.faction_id
+WHERE p.id = cp.player_id;
+
+DROP INDEX IF EXISTS idx_players_faction;
+ALTER TABLE players DROP COLUMN IF EXISTS faction_id;
+DROP TABLE IF EXISTS factions;
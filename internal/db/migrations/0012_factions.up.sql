@@ -0,0 +1,43 @@
+CREATE TABLE factions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE players ADD COLUMN faction_id INTEGER REFERENCES factions(id) ON DELETE SET NULL;
+CREATE INDEX idx_players_faction ON players(faction_id);
+
+-- Factions typed in /register become real factions; from now on admins
+-- assign them.
+INSERT INTO factions (name)
+SELECT DISTINCT faction FROM character_profiles WHERE COALESCE(faction, '') <> ''
+ON CONFLICT (name) DO NOTHING;
+UPDATE players p
+SET faction_id = f.id
+FROM character_profiles cp
+JOIN factions f ON f.name = cp.faction
+WHERE cp.player_id = p.id;
+ALTER TABLE character_profiles DROP COLUMN faction;
+
+ALTER TABLE system_config
+    ADD COLUMN faction_forbid_own_likes BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN faction_cross_weight NUMERIC(4,2) NOT NULL DEFAULT 1 CHECK (faction_cross_weight >= 0);
+
+ALTER TABLE admin_actions DROP CONSTRAINT IF EXISTS admin_actions_action_type_check;
+ALTER TABLE admin_actions ADD CONSTRAINT admin_actions_action_type_check CHECK (action_type IN (
+    'create_player',
+    'adjust_rating',
+    'change_cycle_settings',
+    'create_admin',
+    'change_player_role',
+    'regenerate_qr',
+    'force_level_recalc',
+    'set_rating_limits',
+    'refund_ratings',
+    'set_level_boundaries',
+    'change_rating_formula',
+    'create_faction',
+    'set_player_faction'
+));
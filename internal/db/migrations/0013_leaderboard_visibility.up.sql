@@ -0,0 +1 @@
+ALTER TABLE system_config ADD COLUMN leaderboard_hide_numbers BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+ALTER TABLE system_config DROP COLUMN IF EXISTS rater_visibility;
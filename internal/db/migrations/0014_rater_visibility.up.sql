@@ -0,0 +1,2 @@
+ALTER TABLE system_config ADD COLUMN rater_visibility VARCHAR(20) NOT NULL DEFAULT 'admins'
+    CHECK (rater_visibility IN ('anonymous', 'admins', 'after_cycle'));
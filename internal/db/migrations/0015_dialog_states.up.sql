@@ -0,0 +1,8 @@
+CREATE TABLE dialog_states (
+    telegram_id BIGINT PRIMARY KEY,
+    dialog VARCHAR(50) NOT NULL,
+    step VARCHAR(50) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}'::jsonb,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
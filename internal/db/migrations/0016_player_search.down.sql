@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_character_profiles_name_trgm;
+DROP INDEX IF EXISTS idx_players_full_name_trgm;
+DROP INDEX IF EXISTS idx_players_username_lower;
@@ -0,0 +1,5 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_players_username_lower ON players (LOWER(username));
+CREATE INDEX idx_players_full_name_trgm ON players USING GIN (full_name gin_trgm_ops);
+CREATE INDEX idx_character_profiles_name_trgm ON character_profiles USING GIN (character_name gin_trgm_ops);
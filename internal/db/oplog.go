@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// playerSnapshot is the state of a player around a logged operation.
+type playerSnapshot struct {
+	ID           int
+	Level        int
+	RatingBefore int
+	RatingAfter  int
+}
+
+func snapshot(player Player, ratingChange int) *playerSnapshot {
+	return &playerSnapshot{
+		ID:           player.ID,
+		Level:        player.Level,
+		RatingBefore: player.Rating,
+		RatingAfter:  player.Rating + ratingChange,
+	}
+}
+
+// operationEntry is one row of operations_log. Entries are written in the
+// transaction of the operation they describe, so for every player the
+// rating_after of one entry is the rating_before of the next.
+type operationEntry struct {
+	Type         string
+	CycleID      *int
+	Initiator    *playerSnapshot
+	Target       *playerSnapshot
+	RatingChange *int
+	RatingValue  *int
+	Details      any
+}
+
+func logOperation(ctx context.Context, q querier, entry operationEntry) (int64, error) {
+	args := []any{entry.Type, entry.CycleID}
+	for _, s := range []*playerSnapshot{entry.Initiator, entry.Target} {
+		if s == nil {
+			args = append(args, nil, nil, nil, nil)
+			continue
+		}
+		args = append(args, s.ID, s.Level, s.RatingBefore, s.RatingAfter)
+	}
+	var details any
+	if entry.Details != nil {
+		data, err := json.Marshal(entry.Details)
+		if err != nil {
+			return 0, err
+		}
+		details = string(data)
+	}
+	args = append(args, entry.RatingChange, entry.RatingValue, details)
+
+	var id int64
+	err := q.QueryRow(ctx, `
+		INSERT INTO operations_log (
+			operation_type, game_cycle_id,
+			initiator_id, initiator_level, initiator_rating_before, initiator_rating_after,
+			target_id, target_level, target_rating_before, target_rating_after,
+			rating_change, rating_value, details
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id
+	`, args...).Scan(&id)
+	return id, err
+}
@@ -0,0 +1,375 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"rts_for_rating_on_larp/internal/formula"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrPlayerNotFound     = errors.New("player not found")
+	ErrSelfInteraction    = errors.New("player cannot interact with themselves")
+	ErrRatingTimeout      = errors.New("rating timeout has not expired")
+	ErrRatingLimitReached = errors.New("rating limit reached")
+	ErrInsufficientRating = errors.New("insufficient rating")
+)
+
+// RatingRequest describes a rating to be created. Calculate receives the
+// players as they are locked inside the transaction together with the repeat
+// penalty and returns the rating change with its breakdown, which is stored
+// in player_ratings.calculation_details.
+type RatingRequest struct {
+	RaterID    int
+	RatedID    int
+	Cycle      GameCycle
+	RatingType string
+	Penalty    PenaltyRule
+	Factions   FactionRules
+	Calculate  func(in RatingInput) (RatingCalculation, error)
+}
+
+type RatingInput struct {
+	Rater Player
+	Rated Player
+	// PreviousRatings is how many times the rater already rated this player
+	// within the penalty window.
+	PreviousRatings    int
+	PenaltyCoefficient float64
+}
+
+// RatingCalculation is the breakdown of a single rating change.
+type RatingCalculation struct {
+	RaterLevel     int            `json:"rater_level"`
+	RatedLevel     int            `json:"rated_level"`
+	RaterRating    int            `json:"rater_rating"`
+	RatedRating    int            `json:"rated_rating"`
+	Formula        string         `json:"formula,omitempty"`
+	FormulaVersion int            `json:"formula_version,omitempty"`
+	Strategy       string         `json:"strategy,omitempty"`
+	Params         formula.Params `json:"params,omitempty"`
+	// FormulaA and FormulaB are only set on ratings made before formulas
+	// became selectable.
+	FormulaA           float64 `json:"formula_a,omitempty"`
+	FormulaB           float64 `json:"formula_b,omitempty"`
+	RawValue           float64 `json:"raw_value"`
+	PreviousRatings    int     `json:"previous_ratings"`
+	PenaltyCoefficient float64 `json:"penalty_coefficient"`
+	// FactionWeight is only set when a cross-faction weight other than 1
+	// applied to the rating; PenalizedValue then includes it.
+	FactionWeight  *float64 `json:"faction_weight,omitempty"`
+	PenalizedValue float64  `json:"penalized_value"`
+	Rounded        int      `json:"rounded"`
+	MinimumApplied bool     `json:"minimum_applied"`
+	Result         int      `json:"result"`
+	ConfigVersion  int      `json:"config_version"`
+	// RaterHidden is set by GetRating when the rater is anonymous to the
+	// viewer; the fields that could identify the rater are zero then.
+	RaterHidden bool `json:"-"`
+}
+
+// hideRater clears what identifies the rater: the exact rater rating, the
+// values derived from it by rating-based strategies and the number of the
+// pair's previous ratings. Levels stay, they are shared by many players.
+func (c *RatingCalculation) hideRater() {
+	c.RaterRating, c.PreviousRatings = 0, 0
+	c.RawValue, c.PenalizedValue = 0, 0
+	c.RaterHidden = true
+}
+
+type RatingRecord struct {
+	ID        int64
+	RaterID   int
+	RaterName string
+	// RaterHidden is set when the anonymity policy hides the rater from the
+	// viewer; RaterID and RaterName are zero then.
+	RaterHidden        bool
+	RatedID            int
+	RatedName          string
+	RatingType         string
+	RatingValue        int
+	PenaltyCoefficient float64
+	CycleNumber        int
+	CreatedAt          time.Time
+	// Details is nil for ratings created before breakdowns were stored.
+	Details *RatingCalculation
+}
+
+// Coefficient returns the weight of a rating preceded by previous ratings of
+// the same pair. The result has two decimals to match the stored column.
+func (r PenaltyRule) Coefficient(previous int) float64 {
+	coefficient := 1 - r.Step*float64(previous)
+	if coefficient < r.Min {
+		coefficient = r.Min
+	}
+	coefficient = math.Max(0, math.Min(1, coefficient))
+	return math.Round(coefficient*100) / 100
+}
+
+// CreateRating enforces the rating timeout and the rater's budget and stores
+// the rating atomically. Both players are locked for the duration of the
+// transaction, so concurrent requests from the same rater are serialized.
+func (s *Store) CreateRating(ctx context.Context, req RatingRequest) (RatingResult, error) {
+	if req.RaterID == req.RatedID {
+		return RatingResult{}, ErrSelfInteraction
+	}
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return RatingResult{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	players, err := lockPlayers(ctx, tx, req.RaterID, req.RatedID)
+	if err != nil {
+		return RatingResult{}, err
+	}
+	rater, rated := players[req.RaterID], players[req.RatedID]
+	if req.RatingType == "like" && req.Factions.ForbidOwnLikes && rater.FactionID != nil &&
+		rated.FactionID != nil && *rater.FactionID == *rated.FactionID {
+		return RatingResult{}, ErrOwnFactionLike
+	}
+
+	var lastRatingAt time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT created_at FROM player_ratings
+		WHERE rater_id = $1 AND rated_id = $2
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, rater.ID, rated.ID).Scan(&lastRatingAt)
+	switch {
+	case err == nil:
+		if time.Since(lastRatingAt) < time.Duration(req.Cycle.RatingTimeoutMinutes)*time.Minute {
+			return RatingResult{}, ErrRatingTimeout
+		}
+	case !errors.Is(err, pgx.ErrNoRows):
+		return RatingResult{}, err
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE players
+		SET ratings_available = ratings_available - 1, updated_at = NOW()
+		WHERE id = $1 AND (ratings_available IS NULL OR ratings_available > 0)
+	`, rater.ID)
+	if err != nil {
+		return RatingResult{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return RatingResult{}, ErrRatingLimitReached
+	}
+
+	var previous int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM player_ratings
+		WHERE rater_id = $1 AND rated_id = $2
+			AND CASE WHEN $4 > 0
+				THEN created_at > NOW() - make_interval(mins => $4)
+				ELSE game_cycle_id = $3
+			END
+	`, rater.ID, rated.ID, req.Cycle.ID, req.Penalty.WindowMinutes).Scan(&previous); err != nil {
+		return RatingResult{}, err
+	}
+	coefficient := req.Penalty.Coefficient(previous)
+
+	calculation, err := req.Calculate(RatingInput{
+		Rater:              rater,
+		Rated:              rated,
+		PreviousRatings:    previous,
+		PenaltyCoefficient: coefficient,
+	})
+	if err != nil {
+		return RatingResult{}, err
+	}
+	ratingChange := calculation.Result
+	details, err := json.Marshal(calculation)
+	if err != nil {
+		return RatingResult{}, err
+	}
+	var ratingID int64
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO player_ratings (rater_id, rated_id, rating_type, rating_value, base_value, penalty_coefficient, game_cycle_id, calculation_details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, rater.ID, rated.ID, req.RatingType, ratingChange, baseValue(req.RatingType), coefficient, req.Cycle.ID, string(details)).Scan(&ratingID); err != nil {
+		return RatingResult{}, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE players
+		SET current_rating = current_rating + $1, updated_at = NOW()
+		WHERE id = $2
+	`, ratingChange, rated.ID); err != nil {
+		return RatingResult{}, err
+	}
+
+	ratingValue := baseValue(req.RatingType)
+	if _, err := logOperation(ctx, tx, operationEntry{
+		Type:         "rating_" + req.RatingType,
+		CycleID:      &req.Cycle.ID,
+		Initiator:    snapshot(rater, 0),
+		Target:       snapshot(rated, ratingChange),
+		RatingChange: &ratingChange,
+		RatingValue:  &ratingValue,
+		Details: map[string]any{
+			"rating_id":   ratingID,
+			"calculation": calculation,
+		},
+	}); err != nil {
+		return RatingResult{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return RatingResult{}, err
+	}
+	return RatingResult{
+		RatingID:           ratingID,
+		RatingChange:       ratingChange,
+		PenaltyCoefficient: coefficient,
+		RaterLevel:         rater.Level,
+		RatedLevel:         rated.Level,
+	}, nil
+}
+
+// GetRating returns the rating as the viewer may see it.
+func (s *Store) GetRating(ctx context.Context, ratingID int64, viewer Viewer) (RatingRecord, error) {
+	var (
+		record     RatingRecord
+		details    *string
+		cycleEnded bool
+	)
+	err := s.conn.QueryRow(ctx, `
+		SELECT pr.id, pr.rater_id, rater.full_name, pr.rated_id, rated.full_name,
+			pr.rating_type, pr.rating_value, COALESCE(pr.penalty_coefficient, 1)::float8,
+			gc.cycle_number, NOT gc.is_active, pr.created_at, pr.calculation_details
+		FROM player_ratings pr
+		JOIN players rater ON rater.id = pr.rater_id
+		JOIN players rated ON rated.id = pr.rated_id
+		JOIN game_cycles gc ON gc.id = pr.game_cycle_id
+		WHERE pr.id = $1
+	`, ratingID).Scan(&record.ID, &record.RaterID, &record.RaterName, &record.RatedID, &record.RatedName,
+		&record.RatingType, &record.RatingValue, &record.PenaltyCoefficient,
+		&record.CycleNumber, &cycleEnded, &record.CreatedAt, &details)
+	if err != nil {
+		return RatingRecord{}, err
+	}
+	policy, err := raterVisibility(ctx, s.conn)
+	if err != nil {
+		return RatingRecord{}, err
+	}
+	if !viewer.CanSeeRater(policy, record.RaterID, cycleEnded) {
+		record.RaterID, record.RaterName, record.RaterHidden = 0, "", true
+	}
+	if details != nil {
+		var calculation RatingCalculation
+		if err := json.Unmarshal([]byte(*details), &calculation); err == nil {
+			if record.RaterHidden {
+				calculation.hideRater()
+			}
+			record.Details = &calculation
+		}
+	}
+	return record, nil
+}
+
+// CreateTransfer moves amount of rating from sender to receiver. The sender's
+// balance is checked against the locked row, not a previously read copy.
+func (s *Store) CreateTransfer(ctx context.Context, senderID, receiverID, cycleID, amount int, description string) error {
+	if senderID == receiverID {
+		return ErrSelfInteraction
+	}
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	players, err := lockPlayers(ctx, tx, senderID, receiverID)
+	if err != nil {
+		return err
+	}
+	if players[senderID].Rating < amount {
+		return ErrInsufficientRating
+	}
+
+	var transferID int64
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO rating_transfers (sender_id, receiver_id, amount, game_cycle_id, description)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, senderID, receiverID, amount, cycleID, description).Scan(&transferID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE players
+		SET current_rating = current_rating - $1, updated_at = NOW()
+		WHERE id = $2
+	`, amount, senderID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE players
+		SET current_rating = current_rating + $1, updated_at = NOW()
+		WHERE id = $2
+	`, amount, receiverID); err != nil {
+		return err
+	}
+
+	if _, err := logOperation(ctx, tx, operationEntry{
+		Type:         "rating_transfer",
+		CycleID:      &cycleID,
+		Initiator:    snapshot(players[senderID], -amount),
+		Target:       snapshot(players[receiverID], amount),
+		RatingChange: &amount,
+		Details: map[string]any{
+			"transfer_id": transferID,
+			"description": description,
+		},
+	}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// lockPlayers locks the given players in id order, which keeps two opposite
+// operations between the same pair from deadlocking.
+func lockPlayers(ctx context.Context, q querier, ids ...int) (map[int]Player, error) {
+	rows, err := q.Query(ctx, `
+		SELECT `+playerColumns+`
+		FROM players
+		WHERE id = ANY($1)
+		ORDER BY id
+		FOR UPDATE
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Player, error) {
+		return scanPlayer(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	players := make(map[int]Player, len(locked))
+	for _, player := range locked {
+		players[player.ID] = player
+	}
+	for _, id := range ids {
+		if _, ok := players[id]; !ok {
+			return nil, ErrPlayerNotFound
+		}
+	}
+	return players, nil
+}
+
+func baseValue(ratingType string) int {
+	if ratingType == "like" {
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,238 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	RolePlayer     = "player"
+	RoleModerator  = "moderator"
+	RoleAdmin      = "admin"
+	RoleSuperAdmin = "super_admin"
+)
+
+var (
+	ErrInvalidRole    = errors.New("invalid role")
+	ErrRoleForbidden  = errors.New("role change not allowed")
+	ErrLastSuperAdmin = errors.New("cannot demote the last super admin")
+	ErrOwnRoleChange  = errors.New("cannot change own role")
+	ErrRoleNotRaised  = errors.New("player already has the role or a higher one")
+	ErrAdminExists    = errors.New("an admin is already assigned")
+)
+
+var roleRanks = map[string]int{
+	RolePlayer:     0,
+	RoleModerator:  1,
+	RoleAdmin:      2,
+	RoleSuperAdmin: 3,
+}
+
+func IsRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole reports whether role is at least min. Unknown roles have no rights.
+func HasRole(role, min string) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[min]
+}
+
+// CanChangeRole applies the role policy: super admins may set any role on
+// anyone, admins may manage everyone below super admin but cannot grant it,
+// and nobody else may change roles.
+func CanChangeRole(actorRole, targetRole, newRole string) error {
+	if !IsRole(newRole) {
+		return ErrInvalidRole
+	}
+	switch {
+	case actorRole == RoleSuperAdmin:
+		return nil
+	case actorRole == RoleAdmin && targetRole != RoleSuperAdmin && newRole != RoleSuperAdmin:
+		return nil
+	default:
+		return ErrRoleForbidden
+	}
+}
+
+// RoleChange describes a role change requested through the bot or the web
+// admin. ActorID is nil for the web admin, which acts as ActorRole.
+type RoleChange struct {
+	ActorID          *int
+	ActorRole        string
+	TargetTelegramID int64
+	NewRole          string
+	Source           string
+	// ActionType is recorded in admin_actions; it defaults to
+	// change_player_role.
+	ActionType string
+	// RaiseOnly rejects the change with ErrRoleNotRaised when the target
+	// already has NewRole or a higher one.
+	RaiseOnly bool
+}
+
+// roleLockKey is the Postgres advisory lock that serializes role changes, so
+// that two super admins demoting each other cannot both pass the last super
+// admin check.
+const roleLockKey int64 = 0x5254535f524f4c45
+
+// ChangePlayerRole checks the policy under the role lock, updates the role
+// and records the change_player_role admin action in one transaction. The
+// actor's role is read again inside the transaction; ActorRole is only used
+// for the web admin. It returns the player's previous role and the updated
+// player.
+func (s *Store) ChangePlayerRole(ctx context.Context, change RoleChange) (string, Player, error) {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return "", Player{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", roleLockKey); err != nil {
+		return "", Player{}, err
+	}
+	if change.ActorID != nil {
+		err := tx.QueryRow(ctx, `SELECT role FROM players WHERE id = $1`, *change.ActorID).Scan(&change.ActorRole)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", Player{}, ErrRoleForbidden
+		}
+		if err != nil {
+			return "", Player{}, err
+		}
+	}
+
+	target, err := scanPlayer(tx.QueryRow(ctx, `
+		SELECT `+playerColumns+` FROM players WHERE telegram_id = $1 FOR UPDATE
+	`, change.TargetTelegramID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", Player{}, ErrPlayerNotFound
+	}
+	if err != nil {
+		return "", Player{}, err
+	}
+	if change.ActorID != nil && *change.ActorID == target.ID {
+		return "", Player{}, ErrOwnRoleChange
+	}
+	if err := CanChangeRole(change.ActorRole, target.Role, change.NewRole); err != nil {
+		return "", Player{}, err
+	}
+	if change.RaiseOnly && HasRole(target.Role, change.NewRole) {
+		return "", Player{}, ErrRoleNotRaised
+	}
+	oldRole := target.Role
+	if oldRole == RoleSuperAdmin && change.NewRole != RoleSuperAdmin {
+		rows, err := tx.Query(ctx, `
+			SELECT id FROM players WHERE role = $1 AND id <> $2 ORDER BY id FOR UPDATE
+		`, RoleSuperAdmin, target.ID)
+		if err != nil {
+			return "", Player{}, err
+		}
+		otherIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+		if err != nil {
+			return "", Player{}, err
+		}
+		if len(otherIDs) == 0 {
+			return "", Player{}, ErrLastSuperAdmin
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE players SET role = $1, updated_at = NOW() WHERE id = $2
+	`, change.NewRole, target.ID); err != nil {
+		return "", Player{}, err
+	}
+	target.Role = change.NewRole
+	if change.ActionType == "" {
+		change.ActionType = ActionChangePlayerRole
+	}
+	if err := recordAdminAction(ctx, tx, AdminAction{
+		AdminID:        change.ActorID,
+		Source:         change.Source,
+		ActionType:     change.ActionType,
+		TargetPlayerID: &target.ID,
+		Details: map[string]any{
+			"old_role": oldRole,
+			"new_role": change.NewRole,
+		},
+	}); err != nil {
+		return "", Player{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", Player{}, err
+	}
+	return oldRole, target, nil
+}
+
+// BootstrapSuperAdmin makes the player the first super admin and records the
+// create_admin action as done by the player. The check that nobody has a
+// staff role yet runs under the role lock together with the update, so two
+// players cannot both become the first one.
+func (s *Store) BootstrapSuperAdmin(ctx context.Context, telegramID int64) (Player, error) {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return Player{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", roleLockKey); err != nil {
+		return Player{}, err
+	}
+	hasAnyAdmin, err := (&Store{conn: tx}).HasAnyAdmin(ctx)
+	if err != nil {
+		return Player{}, err
+	}
+	if hasAnyAdmin {
+		return Player{}, ErrAdminExists
+	}
+	target, err := scanPlayer(tx.QueryRow(ctx, `
+		SELECT `+playerColumns+` FROM players WHERE telegram_id = $1 FOR UPDATE
+	`, telegramID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Player{}, ErrPlayerNotFound
+	}
+	if err != nil {
+		return Player{}, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE players SET role = $1, updated_at = NOW() WHERE id = $2
+	`, RoleSuperAdmin, target.ID); err != nil {
+		return Player{}, err
+	}
+	if err := recordAdminAction(ctx, tx, AdminAction{
+		AdminID:        &target.ID,
+		Source:         AdminSourceBot,
+		ActionType:     ActionCreateAdmin,
+		TargetPlayerID: &target.ID,
+		Details: map[string]any{
+			"old_role": target.Role,
+			"new_role": RoleSuperAdmin,
+		},
+	}); err != nil {
+		return Player{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Player{}, err
+	}
+	target.Role = RoleSuperAdmin
+	return target, nil
+}
+
+// ListStaff returns every player with a role above player.
+func (s *Store) ListStaff(ctx context.Context) ([]Player, error) {
+	rows, err := s.conn.Query(ctx, `
+		SELECT `+playerColumns+`
+		FROM players
+		WHERE role <> 'player'
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Player, error) {
+		return scanPlayer(row)
+	})
+}
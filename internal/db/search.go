@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// nameSimilarity is the minimal word similarity (pg_trgm) of a fuzzy name
+// match; substring matches are always found.
+const nameSimilarity = 0.5
+
+// FindPlayerByUsername looks a player up by Telegram username, without the
+// leading @ and ignoring case.
+func (s *Store) FindPlayerByUsername(ctx context.Context, username string) (Player, error) {
+	row := s.conn.QueryRow(ctx, `
+		SELECT `+playerColumns+`
+		FROM players
+		WHERE LOWER(username) = LOWER($1)
+	`, strings.TrimPrefix(username, "@"))
+	player, err := scanPlayer(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Player{}, ErrPlayerNotFound
+	}
+	return player, err
+}
+
+// SearchPlayers finds players whose character or real name contains query or
+// is similar to it, best matches first. When some names match query exactly,
+// only those players are returned.
+func (s *Store) SearchPlayers(ctx context.Context, query string, limit int) ([]Player, error) {
+	return s.searchPlayers(ctx, query, limit, true)
+}
+
+// SearchCharacters is SearchPlayers over character names only, for searches
+// whose results anyone may see.
+func (s *Store) SearchCharacters(ctx context.Context, query string, limit int) ([]Player, error) {
+	return s.searchPlayers(ctx, query, limit, false)
+}
+
+func (s *Store) searchPlayers(ctx context.Context, query string, limit int, realNames bool) ([]Player, error) {
+	rows, err := s.conn.Query(ctx, `
+		WITH matches AS (
+			SELECT p.id,
+				($5 AND LOWER(p.full_name) = LOWER($1)) OR LOWER(COALESCE(cp.character_name, '')) = LOWER($1) AS exact,
+				GREATEST(CASE WHEN $5 THEN word_similarity($1, p.full_name) ELSE 0 END,
+					COALESCE(word_similarity($1, cp.character_name), 0)) AS score
+			FROM players p
+			LEFT JOIN character_profiles cp ON cp.player_id = p.id
+			WHERE ($5 AND p.full_name ILIKE '%' || $2 || '%')
+				OR cp.character_name ILIKE '%' || $2 || '%'
+				OR ($5 AND word_similarity($1, p.full_name) >= $3)
+				OR word_similarity($1, cp.character_name) >= $3
+		)
+		SELECT `+playerColumns+`
+		FROM players
+		JOIN matches USING (id)
+		WHERE exact OR NOT EXISTS (SELECT 1 FROM matches WHERE exact)
+		ORDER BY score DESC, id
+		LIMIT $4
+	`, query, escapeLike(query), nameSimilarity, limit, realNames)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Player, error) {
+		return scanPlayer(row)
+	})
+}
+
+// UpdatePlayerUsername keeps the stored username in step with Telegram, so
+// that players can be found by it.
+func (s *Store) UpdatePlayerUsername(ctx context.Context, playerID int, username string) error {
+	_, err := s.conn.Exec(ctx, `UPDATE players SET username = $1, updated_at = NOW() WHERE id = $2`, username, playerID)
+	return err
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
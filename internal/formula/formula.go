@@ -0,0 +1,170 @@
+package formula
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Input is everything a strategy may use to compute a rating change. Sign is
+// +1 for a like and -1 for a dislike.
+type Input struct {
+	RaterLevel  int     `json:"rater_level"`
+	RatedLevel  int     `json:"rated_level"`
+	RaterRating int     `json:"rater_rating"`
+	RatedRating int     `json:"rated_rating"`
+	Sign        float64 `json:"-"`
+}
+
+type Params map[string]float64
+
+// Strategy is a named rating formula. Strategies are plain Go code selected by
+// name, so a formula stored in the database can never run arbitrary logic.
+type Strategy interface {
+	Name() string
+	Description() string
+	Defaults() Params
+	// Validate rejects parameters the formula cannot be evaluated with, such
+	// as a zero divisor. It gets the parameters with defaults filled in.
+	Validate(p Params) error
+	Evaluate(in Input, p Params) float64
+}
+
+// Formula is a strategy together with its parameters.
+type Formula struct {
+	Strategy string
+	Params   Params
+}
+
+// Result is the outcome of Calculate, step by step.
+type Result struct {
+	RawValue       float64
+	PenalizedValue float64
+	Rounded        int
+	MinimumApplied bool
+	Value          int
+}
+
+var registry = map[string]Strategy{}
+
+func register(s Strategy) {
+	registry[s.Name()] = s
+}
+
+func Lookup(name string) (Strategy, bool) {
+	s, ok := registry[name]
+	return s, ok
+}
+
+func Strategies() []Strategy {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	strategies := make([]Strategy, 0, len(names))
+	for _, name := range names {
+		strategies = append(strategies, registry[name])
+	}
+	return strategies
+}
+
+// Validate checks that the strategy exists, that only known parameters are
+// set and that the strategy accepts their values. Missing parameters fall back
+// to the strategy defaults.
+func (f Formula) Validate() error {
+	s, ok := Lookup(f.Strategy)
+	if !ok {
+		return fmt.Errorf("unknown strategy %q", f.Strategy)
+	}
+	defaults := s.Defaults()
+	for name, value := range f.Params {
+		if _, ok := defaults[name]; !ok {
+			return fmt.Errorf("strategy %s has no parameter %q", f.Strategy, name)
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("parameter %q must be a finite number", name)
+		}
+	}
+	return s.Validate(f.Resolved())
+}
+
+// positive is the Validate of strategies whose parameters must be above zero,
+// typically divisors.
+func positive(p Params, names ...string) error {
+	for _, name := range names {
+		if p[name] <= 0 {
+			return fmt.Errorf("parameter %q must be positive", name)
+		}
+	}
+	return nil
+}
+
+// Resolved returns the parameters with strategy defaults filled in.
+func (f Formula) Resolved() Params {
+	resolved := Params{}
+	if s, ok := Lookup(f.Strategy); ok {
+		for name, value := range s.Defaults() {
+			resolved[name] = value
+		}
+	}
+	for name, value := range f.Params {
+		resolved[name] = value
+	}
+	return resolved
+}
+
+// Calculate evaluates the formula, scales it by the repeat penalty coefficient
+// and rounds it. An unpenalized rating always moves the rating by at least one
+// point; a penalized one may round down to zero.
+func (f Formula) Calculate(in Input, coefficient float64) (Result, error) {
+	if err := f.Validate(); err != nil {
+		return Result{}, err
+	}
+	s, _ := Lookup(f.Strategy)
+	raw := s.Evaluate(in, f.Resolved())
+	if math.IsNaN(raw) || math.IsInf(raw, 0) {
+		return Result{}, errors.New("formula produced a non-finite value")
+	}
+	res := Result{RawValue: raw, PenalizedValue: raw * coefficient}
+	res.Rounded = int(math.Round(res.PenalizedValue))
+	res.Value = res.Rounded
+	if res.Rounded == 0 && coefficient >= 1 {
+		res.MinimumApplied = true
+		res.Value = int(in.Sign)
+	}
+	return res, nil
+}
+
+// ParseParams parses "name=value" pairs.
+func ParseParams(args []string) (Params, error) {
+	params := Params{}
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expected name=value, got %q", arg)
+		}
+		parsed, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", name, err)
+		}
+		params[name] = parsed
+	}
+	return params, nil
+}
+
+func (p Params) String() string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%g", name, p[name]))
+	}
+	return strings.Join(parts, " ")
+}
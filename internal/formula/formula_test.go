@@ -0,0 +1,154 @@
+package formula
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		name        string
+		formula     Formula
+		in          Input
+		coefficient float64
+		raw         float64
+		value       int
+		minimum     bool
+	}{
+		{
+			name:        "level_ratio",
+			formula:     Formula{Strategy: LevelRatio},
+			in:          Input{RaterLevel: 3, RatedLevel: 1, Sign: 1},
+			coefficient: 1,
+			raw:         3,
+			value:       3,
+		},
+		{
+			name:        "level_ratio with params",
+			formula:     Formula{Strategy: LevelRatio, Params: Params{"a": 2, "b": 3}},
+			in:          Input{RaterLevel: 3, RatedLevel: 1, Sign: -1},
+			coefficient: 1,
+			raw:         -2,
+			value:       -2,
+		},
+		{
+			name:        "level_capped",
+			formula:     Formula{Strategy: "level_capped"},
+			in:          Input{RaterLevel: 5, RatedLevel: 1, Sign: -1},
+			coefficient: 1,
+			raw:         -3,
+			value:       -3,
+		},
+		{
+			name:        "rating_difference",
+			formula:     Formula{Strategy: "rating_difference"},
+			in:          Input{RaterRating: 1200, RatedRating: 1000, Sign: 1},
+			coefficient: 1,
+			raw:         1.5,
+			value:       2,
+		},
+		{
+			name:        "rating_difference never flips the sign",
+			formula:     Formula{Strategy: "rating_difference"},
+			in:          Input{RaterRating: 0, RatedRating: 1000, Sign: 1},
+			coefficient: 1,
+			raw:         0,
+			value:       1,
+			minimum:     true,
+		},
+		{
+			name:        "elo like",
+			formula:     Formula{Strategy: "elo"},
+			in:          Input{RaterRating: 1000, RatedRating: 1000, Sign: 1},
+			coefficient: 1,
+			raw:         2,
+			value:       2,
+		},
+		{
+			name:        "elo dislike",
+			formula:     Formula{Strategy: "elo"},
+			in:          Input{RaterRating: 1000, RatedRating: 1000, Sign: -1},
+			coefficient: 1,
+			raw:         -2,
+			value:       -2,
+		},
+		{
+			name:        "constant",
+			formula:     Formula{Strategy: "constant", Params: Params{"value": 2}},
+			in:          Input{RaterLevel: 1, RatedLevel: 5, Sign: -1},
+			coefficient: 1,
+			raw:         -2,
+			value:       -2,
+		},
+		{
+			name:        "minimum like",
+			formula:     Formula{Strategy: LevelRatio},
+			in:          Input{RaterLevel: 1, RatedLevel: 5, Sign: 1},
+			coefficient: 1,
+			raw:         0.2,
+			value:       1,
+			minimum:     true,
+		},
+		{
+			name:        "minimum dislike",
+			formula:     Formula{Strategy: LevelRatio},
+			in:          Input{RaterLevel: 1, RatedLevel: 5, Sign: -1},
+			coefficient: 1,
+			raw:         -0.2,
+			value:       -1,
+			minimum:     true,
+		},
+		{
+			name:        "penalized rating may round to zero",
+			formula:     Formula{Strategy: LevelRatio},
+			in:          Input{RaterLevel: 1, RatedLevel: 1, Sign: 1},
+			coefficient: 0.25,
+			raw:         1,
+			value:       0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tt.formula.Calculate(tt.in, tt.coefficient)
+			if err != nil {
+				t.Fatalf("Calculate: %v", err)
+			}
+			if math.Abs(res.RawValue-tt.raw) > 1e-9 {
+				t.Errorf("RawValue = %v, want %v", res.RawValue, tt.raw)
+			}
+			if res.Value != tt.value {
+				t.Errorf("Value = %d, want %d", res.Value, tt.value)
+			}
+			if res.MinimumApplied != tt.minimum {
+				t.Errorf("MinimumApplied = %v, want %v", res.MinimumApplied, tt.minimum)
+			}
+		})
+	}
+}
+
+func TestCalculateInvalid(t *testing.T) {
+	in := Input{RaterLevel: 1, RatedLevel: 1, Sign: 1}
+	tests := []struct {
+		name    string
+		formula Formula
+	}{
+		{name: "unknown strategy", formula: Formula{Strategy: "random"}},
+		{name: "unknown parameter", formula: Formula{Strategy: LevelRatio, Params: Params{"c": 1}}},
+		{name: "NaN parameter", formula: Formula{Strategy: LevelRatio, Params: Params{"a": math.NaN()}}},
+		{name: "infinite parameter", formula: Formula{Strategy: "constant", Params: Params{"value": math.Inf(1)}}},
+		{name: "level_ratio zero b", formula: Formula{Strategy: LevelRatio, Params: Params{"b": 0}}},
+		{name: "level_ratio negative b", formula: Formula{Strategy: LevelRatio, Params: Params{"b": -1}}},
+		{name: "level_capped zero b", formula: Formula{Strategy: "level_capped", Params: Params{"b": 0}}},
+		{name: "rating_difference zero scale", formula: Formula{Strategy: "rating_difference", Params: Params{"scale": 0}}},
+		{name: "rating_difference negative scale", formula: Formula{Strategy: "rating_difference", Params: Params{"scale": -400}}},
+		{name: "elo zero d", formula: Formula{Strategy: "elo", Params: Params{"d": 0}}},
+		{name: "elo negative d", formula: Formula{Strategy: "elo", Params: Params{"d": -400}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.formula.Calculate(in, 1); err == nil {
+				t.Error("Calculate succeeded, want an error")
+			}
+		})
+	}
+}
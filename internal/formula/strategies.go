@@ -0,0 +1,85 @@
+package formula
+
+import "math"
+
+const LevelRatio = "level_ratio"
+
+func init() {
+	register(levelRatio{})
+	register(levelCapped{})
+	register(ratingDifference{})
+	register(elo{})
+	register(constant{})
+}
+
+// levelRatio is the original formula: z·(A·rater level)/(rated level·B).
+type levelRatio struct{}
+
+func (levelRatio) Name() string { return LevelRatio }
+func (levelRatio) Description() string {
+	return "z·(a·уровень оценивающего)/(уровень оцениваемого·b)"
+}
+func (levelRatio) Defaults() Params        { return Params{"a": 1, "b": 1} }
+func (levelRatio) Validate(p Params) error { return positive(p, "b") }
+func (levelRatio) Evaluate(in Input, p Params) float64 {
+	return in.Sign * (p["a"] * float64(in.RaterLevel)) / (float64(in.RatedLevel) * p["b"])
+}
+
+// levelCapped is level_ratio with the absolute value limited to cap.
+type levelCapped struct{}
+
+func (levelCapped) Name() string { return "level_capped" }
+func (levelCapped) Description() string {
+	return "level_ratio, ограниченная по модулю значением cap"
+}
+func (levelCapped) Defaults() Params        { return Params{"a": 1, "b": 1, "cap": 3} }
+func (levelCapped) Validate(p Params) error { return positive(p, "b") }
+func (levelCapped) Evaluate(in Input, p Params) float64 {
+	value := levelRatio{}.Evaluate(in, p)
+	return math.Copysign(math.Min(math.Abs(value), p["cap"]), value)
+}
+
+// ratingDifference grows when a higher rated player rates a lower rated one.
+type ratingDifference struct{}
+
+func (ratingDifference) Name() string { return "rating_difference" }
+func (ratingDifference) Description() string {
+	return "z·base·(1 + (рейтинг оценивающего − рейтинг оцениваемого)/scale), не меньше 0"
+}
+func (ratingDifference) Defaults() Params        { return Params{"base": 1, "scale": 400} }
+func (ratingDifference) Validate(p Params) error { return positive(p, "scale") }
+func (ratingDifference) Evaluate(in Input, p Params) float64 {
+	factor := 1 + float64(in.RaterRating-in.RatedRating)/p["scale"]
+	return in.Sign * p["base"] * math.Max(0, factor)
+}
+
+// elo treats a like as a win and a dislike as a loss of the rated player
+// against the rater, with the usual logistic expected score.
+type elo struct{}
+
+func (elo) Name() string { return "elo" }
+func (elo) Description() string {
+	return "как в Эло: лайк = победа оцениваемого над оценивающим, k — множитель, d — масштаб"
+}
+func (elo) Defaults() Params        { return Params{"k": 4, "d": 400} }
+func (elo) Validate(p Params) error { return positive(p, "d") }
+func (elo) Evaluate(in Input, p Params) float64 {
+	expected := 1 / (1 + math.Pow(10, float64(in.RaterRating-in.RatedRating)/p["d"]))
+	if in.Sign > 0 {
+		return p["k"] * (1 - expected)
+	}
+	return -p["k"] * expected
+}
+
+// constant ignores levels and ratings entirely.
+type constant struct{}
+
+func (constant) Name() string { return "constant" }
+func (constant) Description() string {
+	return "z·value независимо от уровней и рейтингов"
+}
+func (constant) Defaults() Params      { return Params{"value": 1} }
+func (constant) Validate(Params) error { return nil }
+func (constant) Evaluate(in Input, p Params) float64 {
+	return in.Sign * p["value"]
+}
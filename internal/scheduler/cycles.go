@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"rts_for_rating_on_larp/internal/db"
+)
+
+type CycleScheduler struct {
+	store        *db.Store
+	log          *slog.Logger
+	pollInterval time.Duration
+	lastCycleID  int
+}
+
+func NewCycleScheduler(store *db.Store, log *slog.Logger, pollInterval time.Duration) *CycleScheduler {
+	if pollInterval <= 0 {
+		pollInterval = 30 * time.Second
+	}
+	return &CycleScheduler{store: store, log: log, pollInterval: pollInterval}
+}
+
+// Run closes and opens game cycles until ctx is cancelled. It wakes up at the
+// end of the active cycle, but never sleeps longer than the poll interval so
+// that cycles rotated by another replica or changed settings are picked up.
+func (s *CycleScheduler) Run(ctx context.Context) {
+	s.log.Info("cycle scheduler started", "poll_interval", s.pollInterval)
+	for {
+		timer := time.NewTimer(s.tick(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.log.Info("cycle scheduler stopped")
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *CycleScheduler) tick(ctx context.Context) time.Duration {
+	cfg, err := s.store.GetSystemConfig(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.log.Error("cycle scheduler: load system config", "error", err)
+		}
+		return s.pollInterval
+	}
+	cycle, err := s.store.AdvanceCycle(ctx, cfg)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.log.Error("cycle scheduler: advance cycle", "error", err)
+		}
+		return s.pollInterval
+	}
+	if cycle.ID != s.lastCycleID {
+		s.log.Info("active game cycle",
+			"cycle_id", cycle.ID,
+			"cycle_number", cycle.CycleNumber,
+			"start_time", cycle.StartTime,
+			"end_time", cycle.EndTime,
+		)
+		s.lastCycleID = cycle.ID
+	}
+
+	wait := time.Until(cycle.EndTime)
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > s.pollInterval {
+		wait = s.pollInterval
+	}
+	return wait
+}
@@ -0,0 +1,58 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (b *Bot) handleAdjustRating(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) < 3 {
+		return b.reply(message.Chat.ID, "Формат: /adjust_rating <игрок> <+/-изменение> <причина>")
+	}
+	telegramID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Некорректный telegram_id.")
+	}
+	delta, err := strconv.Atoi(args[1])
+	if err != nil || delta == 0 {
+		return b.reply(message.Chat.ID, "Изменение должно быть ненулевым целым числом, например +5 или -3.")
+	}
+	reason := strings.Join(args[2:], " ")
+	target, err := b.store.GetPlayerByTelegramID(ctx, telegramID)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Игрок не найден.")
+	}
+	admin, err := b.store.GetPlayerByTelegramID(ctx, message.From.ID)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Профиль не найден.")
+	}
+
+	adjustment, player, err := b.store.AdjustRating(ctx, db.RatingAdjustment{
+		PlayerID: target.ID,
+		AdminID:  &admin.ID,
+		Amount:   delta,
+		Reason:   reason,
+	}, db.AdminSourceBot)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось изменить рейтинг.")
+	}
+	if err := b.NotifyRatingAdjustment(player, adjustment); err != nil {
+		b.log.Error("notify rating adjustment failed", "player_id", player.ID, "error", err)
+	}
+	return b.reply(message.Chat.ID, fmt.Sprintf("Рейтинг игрока %s изменен на %+d: %d. Причина: %s", player.FullName, delta, player.Rating, reason))
+}
+
+// NotifyRatingAdjustment tells the player about a manual rating change. The
+// web admin uses it too.
+func (b *Bot) NotifyRatingAdjustment(player db.Player, adjustment db.RatingAdjustment) error {
+	text := fmt.Sprintf("Мастер изменил ваш рейтинг на %+d.\nПричина: %s\nТекущий рейтинг: %d", adjustment.Amount, adjustment.Reason, player.Rating)
+	_, err := b.api.Send(tgbotapi.NewMessage(player.Telegram, text))
+	return err
+}
@@ -0,0 +1,121 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const adminLogLimit = 20
+
+// audit applies a privileged command and its audit entry in one transaction:
+// change runs against the transaction's store and fills in the details of
+// the action. A failure of either leaves nothing behind.
+func (b *Bot) audit(ctx context.Context, from *tgbotapi.User, actionType string, change func(tx *db.Store, action *db.AdminAction) error) error {
+	action := db.AdminAction{Source: db.AdminSourceBot, ActionType: actionType}
+	if admin, err := b.store.GetPlayerByTelegramID(ctx, from.ID); err == nil {
+		action.AdminID = &admin.ID
+	}
+	err := b.store.Audit(ctx, action, change)
+	if err != nil {
+		b.log.Error("audited change failed", "action", actionType, "telegram_id", from.ID, "error", err)
+	}
+	return err
+}
+
+// handleAdminLog lists the latest admin actions: /admin_log [тип действия]
+// [игрок]. The player is given like in other commands or by replying to a
+// forwarded message.
+func (b *Bot) handleAdminLog(ctx context.Context, message *tgbotapi.Message) error {
+	filter := db.AdminActionFilter{Limit: adminLogLimit}
+	args := strings.TrimSpace(message.CommandArguments())
+	if first, rest, _ := strings.Cut(args, " "); isActionType(first) {
+		filter.ActionType = first
+		args = strings.TrimSpace(rest)
+	}
+	if args != "" || isForward(message.ReplyToMessage) {
+		ref, rest := splitPlayerRef(args)
+		if rest != "" {
+			ref = args
+		}
+		telegramID, err := b.resolveTarget(ctx, message.ReplyToMessage, ref)
+		var ambiguous ambiguousPlayer
+		switch {
+		case errors.As(err, &ambiguous):
+			return b.reply(message.Chat.ID, "Нашлось несколько игроков. Уточните имя или укажите @username.")
+		case err != nil:
+			return b.reply(message.Chat.ID, err.Error())
+		}
+		player, err := b.store.GetPlayerByTelegramID(ctx, telegramID)
+		if err != nil {
+			return b.reply(message.Chat.ID, "Игрок не найден.")
+		}
+		filter.PlayerID = player.ID
+	}
+	actions, err := b.store.ListAdminActions(ctx, filter)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить журнал.")
+	}
+	if len(actions) == 0 {
+		return b.reply(message.Chat.ID, "Действий не найдено.")
+	}
+	var sb strings.Builder
+	for i := len(actions) - 1; i >= 0; i-- {
+		sb.WriteString(formatAdminAction(actions[i]))
+		sb.WriteString("\n\n")
+	}
+	return b.reply(message.Chat.ID, strings.TrimRight(sb.String(), "\n"))
+}
+
+// isActionType tells an action type filter such as set_rating_limits from a
+// player reference.
+func isActionType(arg string) bool {
+	if kind, _ := classifyPlayerRef(arg); kind != refName || !strings.Contains(arg, "_") {
+		return false
+	}
+	for _, r := range arg {
+		if (r < 'a' || r > 'z') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func formatAdminAction(action db.AdminAction) string {
+	admin := "веб-админка"
+	if action.AdminID != nil {
+		admin = fmt.Sprintf("%s (#%d)", action.AdminName, *action.AdminID)
+	}
+	text := fmt.Sprintf("%s %s — %s", action.CreatedAt.Format("02.01 15:04:05"), action.ActionType, admin)
+	if action.TargetPlayerID != nil {
+		text += fmt.Sprintf(" → %s (#%d)", action.TargetName, *action.TargetPlayerID)
+	}
+	if details, err := json.Marshal(action.Details); err == nil && len(action.Details) > 0 {
+		text += "\n" + string(details)
+	}
+	return text
+}
+
+func ratingLimitValue(limits []db.RatingLimit, level int) any {
+	for _, limit := range limits {
+		if limit.Level == level {
+			return limit.Limit
+		}
+	}
+	return nil
+}
+
+func boundaryValues(boundaries []db.LevelBoundary) map[string][2]int {
+	values := make(map[string][2]int, len(boundaries))
+	for _, boundary := range boundaries {
+		values[strconv.Itoa(boundary.Level)] = [2]int{boundary.MinRating, boundary.MaxRating}
+	}
+	return values
+}
@@ -0,0 +1,68 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var raterVisibilityNames = map[string]string{
+	db.RatersAnonymous:  "авторы оценок скрыты от всех",
+	db.RatersAdmins:     "авторов оценок видят только модераторы и администраторы",
+	db.RatersAfterCycle: "авторы оценок открываются всем после окончания цикла",
+}
+
+// notifyRating tells the rated player about a new rating. The rater is named
+// only if the anonymity policy lets the rated player see them while the cycle
+// is still running.
+func (b *Bot) notifyRating(ctx context.Context, rater db.Player, ratedID int, ratingType string, result db.RatingResult, policy string) error {
+	rated, err := b.store.GetPlayerByID(ctx, ratedID)
+	if err != nil {
+		return err
+	}
+	kind := "👍 Вам поставили лайк"
+	if ratingType == "dislike" {
+		kind = "👎 Вам поставили дизлайк"
+	}
+	text := fmt.Sprintf("%s: %+d (оценка №%d).", kind, result.RatingChange, result.RatingID)
+	if db.PlayerViewer(rated).CanSeeRater(policy, rater.ID, false) {
+		profile, err := b.store.GetCharacterProfile(ctx, rater.ID)
+		if err != nil {
+			return err
+		}
+		text += "\nОт: " + profile.DisplayName(rater)
+	}
+	text += fmt.Sprintf("\nВаш рейтинг: %d", rated.Rating)
+	_, err = b.api.Send(tgbotapi.NewMessage(rated.Telegram, text))
+	return err
+}
+
+func (b *Bot) handleSetRaterVisibility(ctx context.Context, message *tgbotapi.Message) error {
+	policy := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if !db.IsRaterVisibility(policy) {
+		return b.reply(message.Chat.ID, "Формат: /set_rater_visibility <anonymous|admins|after_cycle>\n"+
+			"anonymous — "+raterVisibilityNames[db.RatersAnonymous]+"\n"+
+			"admins — "+raterVisibilityNames[db.RatersAdmins]+"\n"+
+			"after_cycle — "+raterVisibilityNames[db.RatersAfterCycle])
+	}
+	err := b.audit(ctx, message.From, db.ActionChangeCycleSettings, func(tx *db.Store, action *db.AdminAction) error {
+		cfg, err := tx.GetSystemConfig(ctx)
+		if err != nil {
+			return err
+		}
+		action.Details = map[string]any{
+			"setting": "rater_visibility",
+			"old":     cfg.RaterVisibility,
+			"new":     policy,
+		}
+		return tx.UpdateRaterVisibility(ctx, policy)
+	})
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось обновить политику анонимности.")
+	}
+	return b.reply(message.Chat.ID, "Политика обновлена: "+raterVisibilityNames[policy]+".")
+}
@@ -7,39 +7,49 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"math"
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"rts_for_rating_on_larp/internal/db"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/jackc/pgx/v5"
 	"github.com/skip2/go-qrcode"
 )
 
 const (
-	rolePlayer     = "player"
-	roleModerator  = "moderator"
-	roleAdmin      = "admin"
-	roleSuperAdmin = "super_admin"
+	limitScopeCurrent = "current"
+	limitScopeNext    = "next"
 )
 
 type Bot struct {
-	api         *tgbotapi.BotAPI
-	store       *db.Store
-	log         *slog.Logger
-	botLinkBase string
+	api              *tgbotapi.BotAPI
+	store            *db.Store
+	log              *slog.Logger
+	botLinkBase      string
+	characterClasses []string
+	commands         map[string]command
+	dialogs          map[string]dialog
+	commandList      []command
+	middlewares      []middleware
+	limiter          *rateLimiter
 }
 
-func New(api *tgbotapi.BotAPI, store *db.Store, log *slog.Logger, botLinkBase string) *Bot {
+func New(api *tgbotapi.BotAPI, store *db.Store, log *slog.Logger, botLinkBase string, characterClasses []string) *Bot {
 	botLinkBase = strings.TrimSpace(botLinkBase)
 	if botLinkBase == "" {
 		botLinkBase = "https://t.me/novy_rim_bot"
 	}
-	return &Bot{api: api, store: store, log: log, botLinkBase: strings.TrimRight(botLinkBase, "/")}
+	b := &Bot{
+		api:              api,
+		store:            store,
+		log:              log,
+		botLinkBase:      strings.TrimRight(botLinkBase, "/"),
+		characterClasses: characterClasses,
+		limiter:          newRateLimiter(commandRateLimit, commandRateWindow),
+	}
+	b.registerCommands()
+	return b
 }
 
 func (b *Bot) WebhookHandler() http.HandlerFunc {
@@ -84,6 +94,12 @@ func (b *Bot) WebhookHandler() http.HandlerFunc {
 			}
 			command = "callback"
 		}
+		if update.InlineQuery != nil {
+			if update.InlineQuery.From != nil {
+				fromID = update.InlineQuery.From.ID
+			}
+			command = "inline"
+		}
 		b.log.Info("update received",
 			"update_id", update.UpdateID,
 			"message_id", messageID,
@@ -92,6 +108,7 @@ func (b *Bot) WebhookHandler() http.HandlerFunc {
 			"command", command,
 			"has_message", update.Message != nil,
 			"has_callback", update.CallbackQuery != nil,
+			"has_inline", update.InlineQuery != nil || update.ChosenInlineResult != nil,
 		)
 
 		ctx := r.Context()
@@ -104,6 +121,12 @@ func (b *Bot) WebhookHandler() http.HandlerFunc {
 			if err := b.handleCallback(ctx, update.CallbackQuery); err != nil {
 				b.log.Error("handle callback", "error", err)
 			}
+		case update.InlineQuery != nil:
+			if err := b.handleInlineQuery(ctx, update.InlineQuery); err != nil {
+				b.log.Error("handle inline query", "error", err)
+			}
+		case update.ChosenInlineResult != nil:
+			b.handleChosenInlineResult(update.ChosenInlineResult)
 		}
 		w.WriteHeader(http.StatusOK)
 	}
@@ -111,50 +134,18 @@ func (b *Bot) WebhookHandler() http.HandlerFunc {
 
 func (b *Bot) handleMessage(ctx context.Context, message *tgbotapi.Message) error {
 	if !message.IsCommand() {
-		b.log.Info("non-command message ignored", "chat_id", message.Chat.ID, "message_id", message.MessageID)
-		return nil
-	}
-
-	command := message.Command()
-	var fromID int64
-	if message.From != nil {
-		fromID = message.From.ID
+		return b.continueDialog(ctx, message)
 	}
-	b.log.Info("command received", "command", command, "chat_id", message.Chat.ID, "from_id", fromID, "message_id", message.MessageID)
 
-	var err error
-	switch command {
-	case "start":
-		err = b.handleStart(ctx, message)
-	case "register":
-		err = b.handleRegister(ctx, message)
-	case "my_link":
-		err = b.handleMyLink(ctx, message)
-	case "add_player":
-		err = b.handleAddPlayer(ctx, message)
-	case "set_cycle_duration":
-		err = b.handleSetCycleDuration(ctx, message)
-	case "set_rating_timeout":
-		err = b.handleSetRatingTimeout(ctx, message)
-	case "set_rating_limits":
-		err = b.handleSetRatingLimits(ctx, message)
-	case "set_level_boundary":
-		err = b.handleSetLevelBoundary(ctx, message)
-	case "apply_level_recalc":
-		err = b.handleApplyLevelRecalc(ctx, message)
-	case "create_admin":
-		err = b.handleCreateAdmin(ctx, message)
-	case "transfer":
-		err = b.handleTransfer(ctx, message)
-	default:
-		err = b.reply(message.Chat.ID, "Неизвестная команда.")
-	}
-	if err != nil {
-		b.log.Error("command failed", "command", command, "chat_id", message.Chat.ID, "from_id", fromID, "error", err)
-		return err
+	cmd, ok := b.commands[message.Command()]
+	if !ok {
+		// In groups the command may be meant for another bot.
+		if !message.Chat.IsPrivate() {
+			return nil
+		}
+		return b.reply(message.Chat.ID, "Неизвестная команда.")
 	}
-	b.log.Info("command handled", "command", command, "chat_id", message.Chat.ID, "from_id", fromID)
-	return nil
+	return b.dispatch(ctx, cmd, message)
 }
 
 func (b *Bot) handleCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) error {
@@ -169,6 +160,14 @@ func (b *Bot) handleCallback(ctx context.Context, callback *tgbotapi.CallbackQue
 	}
 
 	action := parts[0]
+	switch action {
+	case callbackDialog:
+		return b.handleDialogCallback(ctx, callback, parts)
+	case callbackHistory:
+		return b.handleHistoryCallback(ctx, callback, parts)
+	case callbackPick:
+		return b.handlePickCallback(ctx, callback, parts)
+	}
 	targetID, err := strconv.Atoi(parts[1])
 	if err != nil {
 		return b.answerCallback(callback.ID, "Некорректная цель.")
@@ -179,10 +178,11 @@ func (b *Bot) handleCallback(ctx context.Context, callback *tgbotapi.CallbackQue
 
 	switch action {
 	case "like", "dislike":
-		if err := b.processRating(ctx, actor, targetID, action); err != nil {
+		result, err := b.processRating(ctx, actor, targetID, action)
+		if err != nil {
 			return b.answerCallback(callback.ID, err.Error())
 		}
-		return b.answerCallback(callback.ID, "Оценка учтена.")
+		return b.answerCallback(callback.ID, fmt.Sprintf("Оценка учтена (№%d).", result.RatingID))
 	case "transfer":
 		if len(parts) < 3 {
 			return b.answerCallback(callback.ID, "Укажите сумму перевода.")
@@ -211,37 +211,17 @@ func (b *Bot) handleStart(ctx context.Context, message *tgbotapi.Message) error
 	if err != nil {
 		return b.reply(message.Chat.ID, "Не удалось создать игрока. Попробуйте позже.")
 	}
-	return b.reply(message.Chat.ID, fmt.Sprintf("Привет, %s! Ваш уровень: %d, рейтинг: %d", player.FullName, player.Level, player.Rating))
+	return b.reply(message.Chat.ID, fmt.Sprintf("Привет, %s! Ваш уровень: %d, рейтинг: %d\n%s", player.FullName, player.Level, player.Rating, formatRatingBudget(player)))
 }
 
-func (b *Bot) handleRegister(ctx context.Context, message *tgbotapi.Message) error {
-	args := strings.Fields(message.CommandArguments())
-	if len(args) == 0 {
-		return b.reply(message.Chat.ID, "Формат: /register [роль] <полное имя>")
-	}
-
-	role := rolePlayer
-	fullNameArgs := args
-	if len(args) > 1 && isRole(args[0]) {
-		role = args[0]
-		fullNameArgs = args[1:]
-	}
-
-	fullName := strings.Join(fullNameArgs, " ")
-	if fullName == "" {
-		return b.reply(message.Chat.ID, "Укажите имя персонажа.")
-	}
-
-	player, err := b.ensurePlayer(ctx, message.From)
-	if err != nil {
-		return b.reply(message.Chat.ID, "Не удалось зарегистрировать игрока.")
-	}
-
-	if err := b.store.UpdatePlayerProfile(ctx, player.Telegram, fullName, role); err != nil {
-		return b.reply(message.Chat.ID, "Не удалось обновить анкету.")
+// characterClass finds value in the configured class list, ignoring case.
+func (b *Bot) characterClass(value string) (string, bool) {
+	for _, class := range b.characterClasses {
+		if strings.EqualFold(class, value) {
+			return class, true
+		}
 	}
-
-	return b.reply(message.Chat.ID, fmt.Sprintf("Анкета обновлена: %s (%s)", fullName, role))
+	return "", false
 }
 
 func (b *Bot) handleMyLink(ctx context.Context, message *tgbotapi.Message) error {
@@ -272,19 +252,28 @@ func (b *Bot) buildPlayerLink(linkHash string) string {
 }
 
 func (b *Bot) handleAddPlayer(ctx context.Context, message *tgbotapi.Message) error {
-	if err := b.requireAdmin(ctx, message.From.ID, message.Chat.ID); err != nil {
-		return err
-	}
 	args := strings.Fields(message.CommandArguments())
 	if len(args) < 2 {
-		return b.reply(message.Chat.ID, "Формат: /add_player <telegram_id> <полное имя>")
+		return b.reply(message.Chat.ID, "Формат: /add_player <игрок> <полное имя>")
 	}
 	telegramID, err := strconv.ParseInt(args[0], 10, 64)
 	if err != nil {
 		return b.reply(message.Chat.ID, "Некорректный telegram_id.")
 	}
 	fullName := strings.Join(args[1:], " ")
-	player, err := b.store.CreatePlayer(ctx, telegramID, "", fullName)
+	var player db.Player
+	err = b.audit(ctx, message.From, db.ActionCreatePlayer, func(tx *db.Store, action *db.AdminAction) error {
+		var err error
+		if player, err = tx.CreatePlayer(ctx, telegramID, "", fullName); err != nil {
+			return err
+		}
+		action.TargetPlayerID = &player.ID
+		action.Details = map[string]any{
+			"telegram_id": telegramID,
+			"full_name":   fullName,
+		}
+		return nil
+	})
 	if err != nil {
 		return b.reply(message.Chat.ID, "Не удалось создать игрока.")
 	}
@@ -296,40 +285,82 @@ func (b *Bot) handleAddPlayer(ctx context.Context, message *tgbotapi.Message) er
 }
 
 func (b *Bot) handleSetCycleDuration(ctx context.Context, message *tgbotapi.Message) error {
-	if err := b.requireAdmin(ctx, message.From.ID, message.Chat.ID); err != nil {
-		return err
-	}
 	minutes, err := strconv.Atoi(strings.TrimSpace(message.CommandArguments()))
 	if err != nil || minutes < 15 {
 		return b.reply(message.Chat.ID, "Укажите длительность в минутах (>= 15).")
 	}
-	if err := b.store.UpdateCycleDuration(ctx, minutes); err != nil {
+	err = b.audit(ctx, message.From, db.ActionChangeCycleSettings, func(tx *db.Store, action *db.AdminAction) error {
+		cfg, err := tx.GetSystemConfig(ctx)
+		if err != nil {
+			return err
+		}
+		action.Details = map[string]any{
+			"setting": "cycle_duration_minutes",
+			"old":     cfg.DefaultCycleDuration,
+			"new":     minutes,
+		}
+		return tx.UpdateCycleDuration(ctx, minutes)
+	})
+	if err != nil {
 		return b.reply(message.Chat.ID, "Не удалось обновить длительность цикла.")
 	}
 	return b.reply(message.Chat.ID, fmt.Sprintf("Длительность цикла обновлена: %d мин.", minutes))
 }
 
 func (b *Bot) handleSetRatingTimeout(ctx context.Context, message *tgbotapi.Message) error {
-	if err := b.requireAdmin(ctx, message.From.ID, message.Chat.ID); err != nil {
-		return err
-	}
 	minutes, err := strconv.Atoi(strings.TrimSpace(message.CommandArguments()))
 	if err != nil || minutes <= 0 {
 		return b.reply(message.Chat.ID, "Укажите таймаут в минутах (> 0).")
 	}
-	if err := b.store.UpdateRatingTimeout(ctx, minutes); err != nil {
+	err = b.audit(ctx, message.From, db.ActionChangeCycleSettings, func(tx *db.Store, action *db.AdminAction) error {
+		cfg, err := tx.GetSystemConfig(ctx)
+		if err != nil {
+			return err
+		}
+		action.Details = map[string]any{
+			"setting": "rating_timeout_minutes",
+			"old":     cfg.DefaultRatingTimeout,
+			"new":     minutes,
+		}
+		return tx.UpdateRatingTimeout(ctx, minutes)
+	})
+	if err != nil {
 		return b.reply(message.Chat.ID, "Не удалось обновить таймаут оценок.")
 	}
 	return b.reply(message.Chat.ID, fmt.Sprintf("Таймаут оценок обновлен: %d мин.", minutes))
 }
 
-func (b *Bot) handleSetRatingLimits(ctx context.Context, message *tgbotapi.Message) error {
-	if err := b.requireAdmin(ctx, message.From.ID, message.Chat.ID); err != nil {
-		return err
+func (b *Bot) handleSetRepeatPenalty(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 2 && len(args) != 3 {
+		return b.reply(message.Chat.ID, "Формат: /set_repeat_penalty <шаг 0-1> <минимум 0-1> [окно в минутах, 0 = цикл]")
+	}
+	rule, err := parsePenaltyRule(args)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Шаг и минимум должны быть от 0 до 1, окно — не меньше 0.")
+	}
+	err = b.audit(ctx, message.From, db.ActionChangeCycleSettings, func(tx *db.Store, action *db.AdminAction) error {
+		cfg, err := tx.GetSystemConfig(ctx)
+		if err != nil {
+			return err
+		}
+		action.Details = map[string]any{
+			"setting": "repeat_penalty",
+			"old":     cfg.RepeatPenalty,
+			"new":     rule,
+		}
+		return tx.UpdateRepeatPenalty(ctx, rule)
+	})
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось обновить штраф за повторные оценки.")
 	}
+	return b.reply(message.Chat.ID, "Штраф за повторные оценки: "+formatPenaltyRule(rule))
+}
+
+func (b *Bot) handleSetRatingLimits(ctx context.Context, message *tgbotapi.Message) error {
 	args := strings.Fields(message.CommandArguments())
-	if len(args) != 2 {
-		return b.reply(message.Chat.ID, "Формат: /set_rating_limits <уровень 1-5> <лимит>")
+	if len(args) != 2 && len(args) != 3 {
+		return b.reply(message.Chat.ID, "Формат: /set_rating_limits <уровень 1-5> <лимит> [current|next]")
 	}
 	level, err := strconv.Atoi(args[0])
 	if err != nil || level < 1 || level > 5 {
@@ -339,16 +370,124 @@ func (b *Bot) handleSetRatingLimits(ctx context.Context, message *tgbotapi.Messa
 	if err != nil || limit <= 0 {
 		return b.reply(message.Chat.ID, "Лимит должен быть больше 0.")
 	}
-	if err := b.store.UpsertRatingLimit(ctx, level, limit); err != nil {
-		return b.reply(message.Chat.ID, "Не удалось обновить лимит.")
+	scope := limitScopeNext
+	if len(args) == 3 {
+		scope = args[2]
+	}
+	switch scope {
+	case limitScopeNext:
+		err := b.audit(ctx, message.From, db.ActionSetRatingLimits, func(tx *db.Store, action *db.AdminAction) error {
+			previous, err := tx.ListSystemRatingLimits(ctx)
+			if err != nil {
+				return err
+			}
+			action.Details = map[string]any{
+				"scope": scope,
+				"level": level,
+				"old":   ratingLimitValue(previous, level),
+				"new":   limit,
+			}
+			return tx.UpsertRatingLimit(ctx, level, limit)
+		})
+		if err != nil {
+			return b.reply(message.Chat.ID, "Не удалось обновить лимит.")
+		}
+		return b.reply(message.Chat.ID, fmt.Sprintf("Лимит для уровня %d со следующего цикла: %d.", level, limit))
+	case limitScopeCurrent:
+		cfg, err := b.store.GetSystemConfig(ctx)
+		if err != nil {
+			return b.reply(message.Chat.ID, "Не удалось получить настройки.")
+		}
+		cycle, err := b.store.EnsureActiveCycle(ctx, cfg)
+		if err != nil {
+			return b.reply(message.Chat.ID, "Не удалось получить цикл.")
+		}
+		err = b.audit(ctx, message.From, db.ActionSetRatingLimits, func(tx *db.Store, action *db.AdminAction) error {
+			previous, err := tx.ListCycleRatingLimits(ctx, cycle.ID)
+			if err != nil {
+				return err
+			}
+			action.Details = map[string]any{
+				"scope":        scope,
+				"cycle_number": cycle.CycleNumber,
+				"level":        level,
+				"old":          ratingLimitValue(previous, level),
+				"new":          limit,
+			}
+			return tx.UpsertCycleRatingLimit(ctx, cycle.ID, level, limit)
+		})
+		if err != nil {
+			return b.reply(message.Chat.ID, "Не удалось обновить лимит.")
+		}
+		return b.reply(message.Chat.ID, fmt.Sprintf("Лимит для уровня %d в текущем цикле %d: %d.", level, cycle.CycleNumber, limit))
+	default:
+		return b.reply(message.Chat.ID, "Укажите current (текущий цикл) или next (со следующего цикла).")
 	}
-	return b.reply(message.Chat.ID, fmt.Sprintf("Лимит для уровня %d обновлен: %d.", level, limit))
 }
 
-func (b *Bot) handleSetLevelBoundary(ctx context.Context, message *tgbotapi.Message) error {
-	if err := b.requireAdmin(ctx, message.From.ID, message.Chat.ID); err != nil {
-		return err
+func (b *Bot) handleRatingLimits(ctx context.Context, message *tgbotapi.Message) error {
+	cfg, err := b.store.GetSystemConfig(ctx)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить настройки.")
+	}
+	cycle, err := b.store.EnsureActiveCycle(ctx, cfg)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить цикл.")
+	}
+	current, err := b.store.ListCycleRatingLimits(ctx, cycle.ID)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить лимиты.")
+	}
+	next, err := b.store.ListSystemRatingLimits(ctx)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить лимиты.")
 	}
+	text := fmt.Sprintf("Текущий цикл %d:\n%s\n\nСо следующего цикла:\n%s",
+		cycle.CycleNumber, formatRatingLimits(current), formatRatingLimits(next))
+	return b.reply(message.Chat.ID, text)
+}
+
+func (b *Bot) handleRefundRatings(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 2 {
+		return b.reply(message.Chat.ID, "Формат: /refund_ratings <игрок> <количество>")
+	}
+	telegramID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Некорректный telegram_id.")
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count <= 0 {
+		return b.reply(message.Chat.ID, "Количество должно быть больше 0.")
+	}
+	player, err := b.store.GetPlayerByTelegramID(ctx, telegramID)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Игрок не найден.")
+	}
+	err = b.audit(ctx, message.From, db.ActionRefundRatings, func(tx *db.Store, action *db.AdminAction) error {
+		before := player.RatingsAvailable
+		var err error
+		if player, err = tx.RefundRatings(ctx, player.ID, count); err != nil {
+			return err
+		}
+		action.TargetPlayerID = &player.ID
+		action.Details = map[string]any{
+			"count": count,
+			"old":   before,
+			"new":   player.RatingsAvailable,
+		}
+		return nil
+	})
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось вернуть оценки.")
+	}
+	if player.RatingsAvailable == nil {
+		return b.reply(message.Chat.ID, fmt.Sprintf("У игрока %s нет лимита оценок.", player.FullName))
+	}
+	return b.reply(message.Chat.ID, fmt.Sprintf("Игроку %s возвращено оценок: %d. Доступно: %d.", player.FullName, count, *player.RatingsAvailable))
+}
+
+func (b *Bot) handleSetLevelBoundary(ctx context.Context, message *tgbotapi.Message) error {
 	args := strings.Fields(message.CommandArguments())
 	if len(args) != 3 {
 		return b.reply(message.Chat.ID, "Формат: /set_level_boundary <уровень 1-5> <мин рейтинг> <макс рейтинг>")
@@ -373,16 +512,95 @@ func (b *Bot) handleSetLevelBoundary(ctx context.Context, message *tgbotapi.Mess
 	if err != nil {
 		return b.reply(message.Chat.ID, "Не удалось получить цикл.")
 	}
-	if err := b.store.SetLevelBoundary(ctx, cycle.ID, level, minRating, maxRating); err != nil {
+	err = b.audit(ctx, message.From, db.ActionSetLevelBoundaries, func(tx *db.Store, action *db.AdminAction) error {
+		previous, err := tx.ListLevelBoundaries(ctx, cycle.ID)
+		if err != nil {
+			return err
+		}
+		action.Details = map[string]any{
+			"cycle_number": cycle.CycleNumber,
+			"level":        level,
+			"old":          boundaryValues(previous)[strconv.Itoa(level)],
+			"new":          [2]int{minRating, maxRating},
+		}
+		return tx.SetLevelBoundary(ctx, cycle.ID, level, minRating, maxRating)
+	})
+	if err != nil {
 		return b.reply(message.Chat.ID, "Не удалось сохранить границы.")
 	}
 	return b.reply(message.Chat.ID, fmt.Sprintf("Границы уровня %d обновлены: %d-%d", level, minRating, maxRating))
 }
 
-func (b *Bot) handleApplyLevelRecalc(ctx context.Context, message *tgbotapi.Message) error {
-	if err := b.requireAdmin(ctx, message.From.ID, message.Chat.ID); err != nil {
-		return err
+func (b *Bot) handleSetLevelDistribution(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 || len(args) > db.MaxLevel {
+		return b.reply(message.Chat.ID, "Формат: /set_level_distribution <% ур.1> <% ур.2> ... (до 5 значений, сумма 100), например: /set_level_distribution 10 20 40 20 10")
+	}
+	shares := make([]int, 0, len(args))
+	for _, arg := range args {
+		share, err := strconv.Atoi(strings.TrimSuffix(arg, "%"))
+		if err != nil {
+			return b.reply(message.Chat.ID, "Доли должны быть целыми числами.")
+		}
+		shares = append(shares, share)
+	}
+	if err := db.ValidateLevelShares(shares); err != nil {
+		return b.reply(message.Chat.ID, "Доли должны быть положительными и в сумме давать 100.")
+	}
+	cfg, err := b.store.GetSystemConfig(ctx)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить настройки.")
+	}
+	cycle, err := b.store.EnsureActiveCycle(ctx, cfg)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить цикл.")
+	}
+	var boundaries []db.LevelBoundary
+	err = b.audit(ctx, message.From, db.ActionSetLevelBoundaries, func(tx *db.Store, action *db.AdminAction) error {
+		previous, err := tx.ListLevelBoundaries(ctx, cycle.ID)
+		if err != nil {
+			return err
+		}
+		if boundaries, err = tx.ApplyLevelDistribution(ctx, cycle.ID, shares); err != nil {
+			return err
+		}
+		action.Details = map[string]any{
+			"cycle_number": cycle.CycleNumber,
+			"shares":       shares,
+			"old":          boundaryValues(previous),
+			"new":          boundaryValues(boundaries),
+		}
+		return nil
+	})
+	if errors.Is(err, db.ErrNoPlayers) {
+		return b.reply(message.Chat.ID, "Нет игроков для распределения.")
+	}
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось рассчитать границы.")
 	}
+	return b.reply(message.Chat.ID, "Границы рассчитаны по распределению:\n"+formatLevelBoundaries(boundaries))
+}
+
+func (b *Bot) handleLevelDistribution(ctx context.Context, message *tgbotapi.Message) error {
+	cfg, err := b.store.GetSystemConfig(ctx)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить настройки.")
+	}
+	cycle, err := b.store.EnsureActiveCycle(ctx, cfg)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить цикл.")
+	}
+	boundaries, err := b.store.ListLevelBoundaries(ctx, cycle.ID)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить границы.")
+	}
+	if len(boundaries) == 0 {
+		return b.reply(message.Chat.ID, "Границы уровней не заданы.")
+	}
+	return b.reply(message.Chat.ID, fmt.Sprintf("Цикл %d:\n%s", cycle.CycleNumber, formatLevelBoundaries(boundaries)))
+}
+
+func (b *Bot) handleApplyLevelRecalc(ctx context.Context, message *tgbotapi.Message) error {
 	cfg, err := b.store.GetSystemConfig(ctx)
 	if err != nil {
 		return b.reply(message.Chat.ID, "Не удалось получить настройки.")
@@ -398,24 +616,31 @@ func (b *Bot) handleApplyLevelRecalc(ctx context.Context, message *tgbotapi.Mess
 	if len(boundaries) == 0 {
 		return b.reply(message.Chat.ID, "Границы уровней не заданы.")
 	}
-	if err := b.store.RecalculateLevels(ctx, cycle.ID, boundaries); err != nil {
+	admin, err := b.store.GetPlayerByTelegramID(ctx, message.From.ID)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Профиль не найден.")
+	}
+	err = b.audit(ctx, message.From, db.ActionForceLevelRecalc, func(tx *db.Store, action *db.AdminAction) error {
+		action.Details = map[string]any{
+			"cycle_number": cycle.CycleNumber,
+			"boundaries":   boundaries,
+		}
+		return tx.RecalculateLevels(ctx, cycle.ID, boundaries, admin.ID)
+	})
+	if err != nil {
 		return b.reply(message.Chat.ID, "Пересчет уровней не удался.")
 	}
 	return b.reply(message.Chat.ID, "Пересчет уровней завершен.")
 }
 
+// handleCreateAdmin appoints an admin. While the game has no admins the
+// command table lets anyone through, and the first admin may only be oneself.
 func (b *Bot) handleCreateAdmin(ctx context.Context, message *tgbotapi.Message) error {
 	hasAnyAdmin, err := b.store.HasAnyAdmin(ctx)
 	if err != nil {
 		return b.reply(message.Chat.ID, "Не удалось проверить список администраторов.")
 	}
 
-	if hasAnyAdmin {
-		if err := b.requireAdmin(ctx, message.From.ID, message.Chat.ID); err != nil {
-			return err
-		}
-	}
-
 	telegramID, err := strconv.ParseInt(strings.TrimSpace(message.CommandArguments()), 10, 64)
 	if err != nil {
 		return b.reply(message.Chat.ID, "Укажите корректный telegram_id.")
@@ -423,24 +648,32 @@ func (b *Bot) handleCreateAdmin(ctx context.Context, message *tgbotapi.Message)
 
 	if !hasAnyAdmin {
 		if message.From == nil || telegramID != message.From.ID {
-			return b.reply(message.Chat.ID, "Первого администратора можно назначить только на себя: /create_admin <ваш_telegram_id>.")
+			return b.reply(message.Chat.ID, "Первого администратора можно назначить только на себя: /create_admin <ваш @username или telegram_id>.")
 		}
-		if err := b.store.SetPlayerRole(ctx, telegramID, roleSuperAdmin); err != nil {
+		self, err := b.store.BootstrapSuperAdmin(ctx, telegramID)
+		switch {
+		case errors.Is(err, db.ErrAdminExists):
+			return b.reply(message.Chat.ID, "Первый администратор уже назначен.")
+		case err != nil:
+			b.log.Error("bootstrap super admin", "telegram_id", telegramID, "error", err)
 			return b.reply(message.Chat.ID, "Не удалось назначить первого администратора. Сначала выполните /start.")
 		}
+		b.refreshCommandMenu(self)
 		return b.reply(message.Chat.ID, "Вы назначены первым администратором (super_admin).")
 	}
 
-	if err := b.store.SetPlayerRole(ctx, telegramID, roleAdmin); err != nil {
-		return b.reply(message.Chat.ID, "Не удалось назначить администратора.")
+	_, player, err := b.changeRole(ctx, message.From, telegramID, db.RoleAdmin, db.ActionCreateAdmin)
+	if err != nil {
+		return b.reply(message.Chat.ID, roleError(err, "Не удалось назначить администратора.").Error())
 	}
+	b.refreshCommandMenu(player)
 	return b.reply(message.Chat.ID, "Администратор назначен.")
 }
 
 func (b *Bot) handleTransfer(ctx context.Context, message *tgbotapi.Message) error {
 	args := strings.Fields(message.CommandArguments())
 	if len(args) != 2 {
-		return b.reply(message.Chat.ID, "Формат: /transfer <telegram_id> <сумма>")
+		return b.reply(message.Chat.ID, "Формат: /transfer <игрок> <сумма>")
 	}
 	telegramID, err := strconv.ParseInt(args[0], 10, 64)
 	if err != nil {
@@ -462,78 +695,68 @@ func (b *Bot) handleTransfer(ctx context.Context, message *tgbotapi.Message) err
 	if sender.ID == receiver.ID {
 		return b.reply(message.Chat.ID, "Нельзя переводить себе.")
 	}
-	if err := b.processTransferWithPlayers(ctx, sender, receiver, amount); err != nil {
+	if err := b.processTransfer(ctx, sender, receiver.ID, amount); err != nil {
 		return b.reply(message.Chat.ID, err.Error())
 	}
 	return b.reply(message.Chat.ID, "Перевод выполнен.")
 }
 
-func (b *Bot) processRating(ctx context.Context, actor db.Player, targetID int, ratingType string) error {
-	target, err := b.store.GetPlayerByID(ctx, targetID)
+func (b *Bot) handleRatingDetails(ctx context.Context, message *tgbotapi.Message) error {
+	ratingID, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(message.CommandArguments()), "№"), 10, 64)
 	if err != nil {
-		return errors.New("Игрок не найден.")
+		return b.reply(message.Chat.ID, "Формат: /rating_details <номер оценки>")
 	}
-
-	cfg, err := b.store.GetSystemConfig(ctx)
+	viewer, err := b.ensurePlayer(ctx, message.From)
 	if err != nil {
-		return errors.New("Настройки недоступны.")
+		return b.reply(message.Chat.ID, "Не удалось определить игрока.")
 	}
-	cycle, err := b.store.EnsureActiveCycle(ctx, cfg)
+	record, err := b.store.GetRating(ctx, ratingID, db.PlayerViewer(viewer))
 	if err != nil {
-		return errors.New("Не удалось получить цикл.")
+		return b.reply(message.Chat.ID, "Оценка не найдена.")
 	}
-
-	lastRatingAt, err := b.store.GetLastRatingBetween(ctx, actor.ID, target.ID)
-	if err == nil {
-		if time.Since(lastRatingAt) < time.Duration(cycle.RatingTimeoutMinutes)*time.Minute {
-			return fmt.Errorf("Слишком частая оценка. Попробуйте позже.")
-		}
-	} else if !errors.Is(err, pgx.ErrNoRows) {
-		return errors.New("Не удалось проверить таймаут.")
+	isAdmin := db.HasRole(viewer.Role, db.RoleModerator)
+	if !isAdmin && viewer.ID != record.RatedID && viewer.ID != record.RaterID {
+		return b.reply(message.Chat.ID, "Недостаточно прав.")
 	}
+	return b.reply(message.Chat.ID, formatRatingRecord(record))
+}
 
-	limit, err := b.store.GetRatingLimit(ctx, actor.Level)
-	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		return errors.New("Не удалось проверить лимиты.")
-	}
-	if err == nil {
-		count, err := b.store.CountRatingsByRaterInCycle(ctx, actor.ID, cycle.ID)
-		if err != nil {
-			return errors.New("Не удалось проверить лимиты.")
-		}
-		if count >= limit.Limit {
-			return errors.New("Лимит оценок за цикл исчерпан.")
-		}
+func (b *Bot) processRating(ctx context.Context, actor db.Player, targetID int, ratingType string) (db.RatingResult, error) {
+	cfg, err := b.store.GetSystemConfig(ctx)
+	if err != nil {
+		return db.RatingResult{}, errors.New("Настройки недоступны.")
 	}
-
-	ratingChange := calculateRatingChange(actor.Level, target.Level, cfg, ratingType)
-	result, err := b.store.CreateRating(ctx, actor, target, cycle, ratingType, ratingChange)
+	cycle, err := b.store.EnsureActiveCycle(ctx, cfg)
 	if err != nil {
-		return errors.New("Не удалось сохранить оценку.")
+		return db.RatingResult{}, errors.New("Не удалось получить цикл.")
 	}
-
-	details := map[string]any{
-		"rating_change": result.RatingChange,
-		"rater_level":   actor.Level,
-		"rated_level":   target.Level,
+	ratingFormula, err := b.store.GetCycleFormula(ctx, cycle.ID)
+	if err != nil {
+		return db.RatingResult{}, errors.New("Не удалось получить формулу рейтинга.")
 	}
-	payload, _ := json.Marshal(details)
-	_ = b.store.LogOperation(ctx, "rating_"+ratingType, &actor.ID, &target.ID, payload)
-	return nil
-}
 
-func (b *Bot) processTransfer(ctx context.Context, actor db.Player, targetID int, amount int) error {
-	target, err := b.store.GetPlayerByID(ctx, targetID)
+	result, err := b.store.CreateRating(ctx, db.RatingRequest{
+		RaterID:    actor.ID,
+		RatedID:    targetID,
+		Cycle:      cycle,
+		RatingType: ratingType,
+		Penalty:    cfg.RepeatPenalty,
+		Factions:   cfg.Factions,
+		Calculate: func(in db.RatingInput) (db.RatingCalculation, error) {
+			return calculateRatingChange(in, ratingFormula, cfg, ratingType)
+		},
+	})
 	if err != nil {
-		return errors.New("Игрок не найден.")
+		return db.RatingResult{}, storeError(err, "Не удалось сохранить оценку.")
 	}
-	return b.processTransferWithPlayers(ctx, actor, target, amount)
+	if err := b.notifyRating(ctx, actor, targetID, ratingType, result, cfg.RaterVisibility); err != nil {
+		b.log.Error("notify rating failed", "rating_id", result.RatingID, "error", err)
+	}
+
+	return result, nil
 }
 
-func (b *Bot) processTransferWithPlayers(ctx context.Context, sender db.Player, receiver db.Player, amount int) error {
-	if sender.Rating < amount {
-		return errors.New("Недостаточно рейтинга.")
-	}
+func (b *Bot) processTransfer(ctx context.Context, sender db.Player, receiverID int, amount int) error {
 	cfg, err := b.store.GetSystemConfig(ctx)
 	if err != nil {
 		return errors.New("Настройки недоступны.")
@@ -542,15 +765,33 @@ func (b *Bot) processTransferWithPlayers(ctx context.Context, sender db.Player,
 	if err != nil {
 		return errors.New("Не удалось получить цикл.")
 	}
-	if err := b.store.CreateTransfer(ctx, sender, receiver, cycle.ID, amount, "manual transfer"); err != nil {
-		return errors.New("Перевод не удался.")
+	if err := b.store.CreateTransfer(ctx, sender.ID, receiverID, cycle.ID, amount, "manual transfer"); err != nil {
+		return storeError(err, "Перевод не удался.")
 	}
-	details := map[string]any{"amount": amount}
-	payload, _ := json.Marshal(details)
-	_ = b.store.LogOperation(ctx, "rating_transfer", &sender.ID, &receiver.ID, payload)
 	return nil
 }
 
+// storeError turns a business rule violation reported by the store into a
+// message for the player; any other error is replaced by fallback.
+func storeError(err error, fallback string) error {
+	switch {
+	case errors.Is(err, db.ErrPlayerNotFound):
+		return errors.New("Игрок не найден.")
+	case errors.Is(err, db.ErrSelfInteraction):
+		return errors.New("Нельзя взаимодействовать с собой.")
+	case errors.Is(err, db.ErrRatingTimeout):
+		return errors.New("Слишком частая оценка. Попробуйте позже.")
+	case errors.Is(err, db.ErrRatingLimitReached):
+		return errors.New("Лимит оценок за цикл исчерпан.")
+	case errors.Is(err, db.ErrInsufficientRating):
+		return errors.New("Недостаточно рейтинга.")
+	case errors.Is(err, db.ErrOwnFactionLike):
+		return errors.New("Нельзя ставить лайки своей фракции.")
+	default:
+		return errors.New(fallback)
+	}
+}
+
 func (b *Bot) showPlayerProfile(ctx context.Context, chatID int64, from *tgbotapi.User, linkHash string) error {
 	viewer, err := b.ensurePlayer(ctx, from)
 	if err != nil {
@@ -560,20 +801,33 @@ func (b *Bot) showPlayerProfile(ctx context.Context, chatID int64, from *tgbotap
 	if err != nil {
 		return b.reply(chatID, "Ссылка не найдена.")
 	}
+	profile, err := b.store.GetCharacterProfile(ctx, target.ID)
+	if err != nil {
+		b.log.Error("get character profile", "player_id", target.ID, "error", err)
+	}
+	card := formatCharacterCard(target, profile)
 	if viewer.ID == target.ID {
-		return b.reply(chatID, fmt.Sprintf("Это ваша карточка: %s (уровень %d, рейтинг %d)", target.FullName, target.Level, target.Rating))
+		text := fmt.Sprintf("Это ваша карточка:\n%s\n\nУровень: %d\nРейтинг: %d\n%s", card, target.Level, target.Rating, formatRatingBudget(viewer))
+		return b.sendCharacterCard(chatID, text, profile, nil)
 	}
 
-	text := fmt.Sprintf("%s\nУровень: %d\nРейтинг: %d", target.FullName, target.Level, target.Rating)
-	msg := tgbotapi.NewMessage(chatID, text)
-	msg.ReplyMarkup = profileKeyboard(target.ID)
-	_, err = b.api.Send(msg)
-	return err
+	text := fmt.Sprintf("%s\n\nУровень: %d\nРейтинг: %d\n\n%s", card, target.Level, target.Rating, formatRatingBudget(viewer))
+	if cfg, err := b.store.GetSystemConfig(ctx); err == nil && !showLeaderboardNumbers(cfg, viewer) {
+		text = fmt.Sprintf("%s\n\nУровень: %d\n\n%s", card, target.Level, formatRatingBudget(viewer))
+	}
+	keyboard := profileKeyboard(target.ID)
+	return b.sendCharacterCard(chatID, text, profile, &keyboard)
 }
 
 func (b *Bot) ensurePlayer(ctx context.Context, user *tgbotapi.User) (db.Player, error) {
 	player, err := b.store.GetPlayerByTelegramID(ctx, user.ID)
 	if err == nil {
+		if player.Username != user.UserName {
+			if err := b.store.UpdatePlayerUsername(ctx, player.ID, user.UserName); err != nil {
+				b.log.Error("update username", "player_id", player.ID, "error", err)
+			}
+			player.Username = user.UserName
+		}
 		return player, nil
 	}
 	fullName := strings.TrimSpace(strings.TrimSpace(user.FirstName + " " + user.LastName))
@@ -583,15 +837,15 @@ func (b *Bot) ensurePlayer(ctx context.Context, user *tgbotapi.User) (db.Player,
 	return b.store.CreatePlayer(ctx, user.ID, user.UserName, fullName)
 }
 
-func (b *Bot) requireAdmin(ctx context.Context, telegramID int64, chatID int64) error {
-	isAdmin, err := b.store.IsAdmin(ctx, telegramID)
-	if err != nil {
-		return b.reply(chatID, "Не удалось проверить права.")
+func (b *Bot) hasRole(ctx context.Context, telegramID int64, minRole string) (bool, error) {
+	role, err := b.store.GetPlayerRole(ctx, telegramID)
+	if errors.Is(err, db.ErrPlayerNotFound) {
+		return false, nil
 	}
-	if !isAdmin {
-		return b.reply(chatID, "Недостаточно прав.")
+	if err != nil {
+		return false, err
 	}
-	return nil
+	return db.HasRole(role, minRole), nil
 }
 
 func (b *Bot) reply(chatID int64, text string) error {
@@ -616,20 +870,120 @@ func (b *Bot) answerCallback(callbackID, text string) error {
 	return err
 }
 
-func calculateRatingChange(raterLevel, ratedLevel int, cfg db.SystemConfig, ratingType string) int {
-	z := 1.0
-	if ratingType == "dislike" {
-		z = -1.0
+func parsePenaltyRule(args []string) (db.PenaltyRule, error) {
+	var rule db.PenaltyRule
+	var err error
+	if rule.Step, err = strconv.ParseFloat(strings.Replace(args[0], ",", ".", 1), 64); err != nil {
+		return rule, err
+	}
+	if rule.Min, err = strconv.ParseFloat(strings.Replace(args[1], ",", ".", 1), 64); err != nil {
+		return rule, err
 	}
-	result := z * (cfg.RatingFormulaA * float64(raterLevel)) / (float64(ratedLevel) * cfg.RatingFormulaB)
-	rounded := int(math.Round(result))
-	if rounded == 0 {
-		if ratingType == "like" {
-			return 1
+	if len(args) > 2 {
+		if rule.WindowMinutes, err = strconv.Atoi(args[2]); err != nil {
+			return rule, err
 		}
-		return -1
 	}
-	return rounded
+	if rule.Step < 0 || rule.Step > 1 || rule.Min < 0 || rule.Min > 1 || rule.WindowMinutes < 0 {
+		return rule, errors.New("penalty rule out of range")
+	}
+	return rule, nil
+}
+
+func formatPenaltyRule(rule db.PenaltyRule) string {
+	window := "в пределах цикла"
+	if rule.WindowMinutes > 0 {
+		window = fmt.Sprintf("за последние %d мин.", rule.WindowMinutes)
+	}
+	return fmt.Sprintf("-%.2f за каждую повторную оценку %s, не ниже %.2f", rule.Step, window, rule.Min)
+}
+
+func formatRatingBudget(player db.Player) string {
+	if player.RatingsAvailable == nil {
+		return "Оценок в этом цикле: без ограничений"
+	}
+	return fmt.Sprintf("Осталось оценок в этом цикле: %d", *player.RatingsAvailable)
+}
+
+func formatRatingRecord(record db.RatingRecord) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Оценка №%d (%s), цикл %d, %s\n", record.ID, record.RatingType, record.CycleNumber, record.CreatedAt.Format("02.01 15:04"))
+	if record.RaterHidden {
+		sb.WriteString("Кто: аноним\n")
+	} else {
+		fmt.Fprintf(&sb, "Кто: %s\n", record.RaterName)
+	}
+	fmt.Fprintf(&sb, "Кому: %s\nИзменение рейтинга: %+d\n", record.RatedName, record.RatingValue)
+	calc := record.Details
+	if calc == nil {
+		fmt.Fprintf(&sb, "Коэффициент штрафа: %.2f\nРасчет не сохранен.", record.PenaltyCoefficient)
+		return sb.String()
+	}
+	if calc.Formula != "" {
+		fmt.Fprintf(&sb, "\nФормула: %s v%d (%s %s)\n", calc.Formula, calc.FormulaVersion, calc.Strategy, calc.Params)
+		if calc.RaterHidden {
+			fmt.Fprintf(&sb, "Уровни: %d → %d\n", calc.RaterLevel, calc.RatedLevel)
+		} else {
+			fmt.Fprintf(&sb, "Уровни: %d → %d, рейтинги: %d → %d\n", calc.RaterLevel, calc.RatedLevel, calc.RaterRating, calc.RatedRating)
+		}
+	} else {
+		fmt.Fprintf(&sb, "\nФормула: z·(A·%d)/(%d·B), A=%.4g, B=%.4g\n", calc.RaterLevel, calc.RatedLevel, calc.FormulaA, calc.FormulaB)
+	}
+	if !calc.RaterHidden {
+		fmt.Fprintf(&sb, "Исходное значение: %.4f\n", calc.RawValue)
+	}
+	if calc.FactionWeight != nil {
+		fmt.Fprintf(&sb, "Вес межфракционной оценки: %.2f\n", *calc.FactionWeight)
+	}
+	if calc.RaterHidden {
+		fmt.Fprintf(&sb, "Коэффициент штрафа: %.2f\n", calc.PenaltyCoefficient)
+	} else {
+		fmt.Fprintf(&sb, "Коэффициент штрафа: %.2f (предыдущих оценок: %d) → %.4f\n", calc.PenaltyCoefficient, calc.PreviousRatings, calc.PenalizedValue)
+	}
+	fmt.Fprintf(&sb, "Округление: %d", calc.Rounded)
+	if calc.MinimumApplied {
+		fmt.Fprintf(&sb, ", применен минимум ±1")
+	}
+	fmt.Fprintf(&sb, "\nИтог: %+d\nВерсия настроек: %d", calc.Result, calc.ConfigVersion)
+	return sb.String()
+}
+
+func formatRatingLimits(limits []db.RatingLimit) string {
+	if len(limits) == 0 {
+		return "без ограничений"
+	}
+	lines := make([]string, 0, len(limits))
+	for _, limit := range limits {
+		lines = append(lines, fmt.Sprintf("Уровень %d: %d", limit.Level, limit.Limit))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatLevelBoundaries(boundaries []db.LevelBoundary) string {
+	var sb strings.Builder
+	for _, boundary := range boundaries {
+		fmt.Fprintf(&sb, "Уровень %d: %s", boundary.Level, formatRatingRange(boundary))
+		if boundary.TargetPercentMax > 0 {
+			fmt.Fprintf(&sb, ", цель %d%%", boundary.TargetPercentMax-boundary.TargetPercentMin)
+		}
+		fmt.Fprintf(&sb, ", факт %.2f%% (%d игр.)\n", boundary.ActualPercentage, boundary.PlayerCount)
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+func formatRatingRange(boundary db.LevelBoundary) string {
+	switch {
+	case boundary.MaxRating < boundary.MinRating:
+		return "пусто"
+	case boundary.UnboundedBelow() && boundary.UnboundedAbove():
+		return "любой рейтинг"
+	case boundary.UnboundedBelow():
+		return fmt.Sprintf("до %d", boundary.MaxRating)
+	case boundary.UnboundedAbove():
+		return fmt.Sprintf("от %d", boundary.MinRating)
+	default:
+		return fmt.Sprintf("%d-%d", boundary.MinRating, boundary.MaxRating)
+	}
 }
 
 func profileKeyboard(targetID int) tgbotapi.InlineKeyboardMarkup {
@@ -644,12 +998,3 @@ func profileKeyboard(targetID int) tgbotapi.InlineKeyboardMarkup {
 		),
 	)
 }
-
-func isRole(value string) bool {
-	switch value {
-	case rolePlayer, roleModerator, roleAdmin, roleSuperAdmin:
-		return true
-	default:
-		return false
-	}
-}
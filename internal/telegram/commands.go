@@ -0,0 +1,231 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type commandHandler func(ctx context.Context, message *tgbotapi.Message) error
+
+// chatScope says where a command may be used.
+type chatScope int
+
+const (
+	// scopePrivate commands work only in a private chat with the bot: they
+	// show personal data or change settings.
+	scopePrivate chatScope = iota
+	// scopeAny commands also work in groups.
+	scopeAny
+)
+
+// command is an entry of the command table. Everything a command needs
+// before its handler runs is declared here and enforced by the middleware
+// chain, so a handler cannot skip a check by forgetting to return.
+type command struct {
+	Name string
+	// MinRole is the minimum permission role; empty means every player.
+	MinRole string
+	// Bootstrap lets the command through without MinRole while the game has
+	// no admins at all, so that the first admin can be appointed.
+	Bootstrap bool
+	// Usage is the argument schema shown in help and on argument errors.
+	Usage string
+	// MinArgs is the number of required space-separated arguments.
+	MinArgs     int
+	Description string
+	Scope       chatScope
+	// Target commands take a player reference as the first argument; see
+	// resolvePlayer.
+	Target bool
+	// Steps, if any, are asked one by one when the command is sent without
+	// arguments.
+	Steps  []dialogStep
+	handle commandHandler
+}
+
+// middleware wraps the handler of cmd. Middlewares run in the order of
+// Bot.middlewares, the first one outermost.
+type middleware func(cmd command, next commandHandler) commandHandler
+
+// rejection is returned by a middleware that refused to run a command after
+// replying to the user. It is not a failure of the bot.
+type rejection struct {
+	reason string
+}
+
+func (r rejection) Error() string { return "command rejected: " + r.reason }
+
+const (
+	rejectedRole      = "forbidden"
+	rejectedRateLimit = "rate_limited"
+	rejectedChat      = "wrong_chat"
+	rejectedArgs      = "bad_args"
+)
+
+// commandTable lists every command of the bot. /help and the Telegram
+// command menu are built from it, in this order.
+func (b *Bot) commandTable() []command {
+	return []command{
+		{Name: "start", Description: "начать игру, показать свой уровень и рейтинг", handle: b.handleStart},
+		{Name: "help", Description: "список доступных команд", Scope: scopeAny, handle: b.handleHelp},
+		{Name: "register", Usage: "[<класс> <имя персонажа>]", Description: "заполнить анкету персонажа", handle: b.handleRegister},
+		{Name: "cancel", Description: "прервать диалог", handle: b.handleCancel},
+		{Name: "my_link", Description: "личная ссылка и QR-код", handle: b.handleMyLink},
+		{Name: "transfer", Usage: "<игрок> <сумма>", MinArgs: 2, Target: true, Description: "перевести рейтинг другому игроку", handle: b.handleTransfer},
+		{Name: "rating_details", Usage: "<номер оценки>", MinArgs: 1, Description: "расчет оценки", handle: b.handleRatingDetails},
+		{Name: "history", Description: "история оценок, переводов и уровней", handle: b.handleHistory},
+		{Name: "top", Usage: "[N] | level <n> [N]", Description: "рейтинг игроков", Scope: scopeAny, handle: b.handleTop},
+		{Name: "movers", Description: "кто больше всех вырос и упал за цикл", Scope: scopeAny, handle: b.handleMovers},
+		{Name: "factions", Description: "рейтинг фракций", Scope: scopeAny, handle: b.handleFactions},
+
+		{Name: "add_player", MinRole: db.RoleModerator, Usage: "<игрок> <полное имя>", MinArgs: 2, Target: true, Description: "добавить игрока", handle: b.handleAddPlayer},
+		{Name: "rating_limits", MinRole: db.RoleModerator, Description: "лимиты оценок", handle: b.handleRatingLimits},
+		{Name: "refund_ratings", MinRole: db.RoleModerator, Usage: "<игрок> <количество>", MinArgs: 2, Target: true, Description: "вернуть игроку оценки", handle: b.handleRefundRatings},
+		{Name: "level_distribution", MinRole: db.RoleModerator, Description: "распределение игроков по уровням", handle: b.handleLevelDistribution},
+		{Name: "formulas", MinRole: db.RoleModerator, Description: "формулы рейтинга", handle: b.handleFormulas},
+		{Name: "formula_dry_run", MinRole: db.RoleModerator, Usage: "<имя[@версия]|стратегия> <уровень оценивающего> <уровень оцениваемого> [рейтинг оценивающего] [рейтинг оцениваемого]", MinArgs: 3, Description: "проверить формулу на примере", handle: b.handleFormulaDryRun},
+		{Name: "admin_log", MinRole: db.RoleModerator, Usage: "[тип действия] [игрок]", Description: "журнал действий администраторов", handle: b.handleAdminLog},
+
+		{Name: "set_cycle_duration", MinRole: db.RoleAdmin, Usage: "<минуты>", MinArgs: 1, Description: "длительность цикла", handle: b.handleSetCycleDuration},
+		{Name: "set_rating_timeout", MinRole: db.RoleAdmin, Usage: "<минуты>", MinArgs: 1, Description: "таймаут между оценками", handle: b.handleSetRatingTimeout},
+		{Name: "set_repeat_penalty", MinRole: db.RoleAdmin, Usage: "<шаг 0-1> <минимум 0-1> [окно в минутах, 0 = цикл]", MinArgs: 2, Description: "штраф за повторные оценки", handle: b.handleSetRepeatPenalty},
+		{Name: "set_rating_limits", MinRole: db.RoleAdmin, Usage: "<уровень 1-5> <лимит> [current|next]", MinArgs: 2, Description: "лимит оценок уровня", handle: b.handleSetRatingLimits},
+		{Name: "set_level_boundary", MinRole: db.RoleAdmin, Usage: "<уровень 1-5> <мин рейтинг> <макс рейтинг>", MinArgs: 3, Description: "границы уровня", handle: b.handleSetLevelBoundary},
+		{Name: "set_level_distribution", MinRole: db.RoleAdmin, Usage: "<% ур.1> <% ур.2> ... (до 5 значений, сумма 100)", MinArgs: 1, Description: "границы уровней по долям игроков", handle: b.handleSetLevelDistribution},
+		{Name: "apply_level_recalc", MinRole: db.RoleAdmin, Description: "пересчитать уровни сейчас", handle: b.handleApplyLevelRecalc},
+		{Name: "save_formula", MinRole: db.RoleAdmin, Usage: "<имя> <стратегия> [параметр=значение ...]", MinArgs: 2, Description: "сохранить формулу", handle: b.handleSaveFormula},
+		{Name: "use_formula", MinRole: db.RoleAdmin, Usage: "<имя>[@версия] [current|next]", MinArgs: 1, Description: "выбрать формулу", handle: b.handleUseFormula},
+		{Name: "adjust_rating", MinRole: db.RoleAdmin, Usage: "<игрок> <+/-изменение> <причина>", MinArgs: 3, Target: true, Description: "изменить рейтинг вручную", handle: b.handleAdjustRating},
+		{Name: "create_admin", MinRole: db.RoleAdmin, Bootstrap: true, Usage: "<игрок>", MinArgs: 1, Target: true, Description: "назначить администратора", handle: b.handleCreateAdmin},
+		{Name: "set_role", MinRole: db.RoleAdmin, Usage: "<игрок> <player|moderator|admin|super_admin>", MinArgs: 2, Target: true, Description: "сменить роль игрока", handle: b.handleSetRole},
+		{Name: "create_faction", MinRole: db.RoleAdmin, Usage: "<название> [| описание]", MinArgs: 1, Description: "создать фракцию", handle: b.handleCreateFaction},
+		{Name: "set_faction", MinRole: db.RoleAdmin, Usage: "<игрок> <фракция или ->", MinArgs: 2, Target: true, Description: "фракция игрока", handle: b.handleSetFaction},
+		{Name: "set_faction_rules", MinRole: db.RoleAdmin, Usage: "<allow|forbid> <вес межфракционных оценок>", MinArgs: 2, Description: "правила фракций", handle: b.handleSetFactionRules},
+		{Name: "set_leaderboard_numbers", MinRole: db.RoleAdmin, Usage: "<show|hide>", MinArgs: 1, Description: "числа в публичных рейтингах", handle: b.handleSetLeaderboardNumbers},
+		{Name: "set_rater_visibility", MinRole: db.RoleAdmin, Usage: "<anonymous|admins|after_cycle>", MinArgs: 1, Description: "кто видит авторов оценок", handle: b.handleSetRaterVisibility},
+	}
+}
+
+func (b *Bot) registerCommands() {
+	b.commandList = b.commandTable()
+	b.commands = make(map[string]command, len(b.commandList))
+	b.dialogs = map[string]dialog{dialogRegister: b.registerDialog()}
+	steps := b.commandSteps()
+	for i, cmd := range b.commandList {
+		if cmdSteps, ok := steps[cmd.Name]; ok {
+			cmd.Steps = cmdSteps
+			b.commandList[i] = cmd
+			b.dialogs[cmd.Name] = b.commandDialog(cmd)
+		}
+		b.commands[cmd.Name] = cmd
+	}
+	b.middlewares = []middleware{b.withLogging, withMetrics, b.withRateLimit, b.withChatScope, b.withAuth, b.withArgs, b.withTarget}
+}
+
+// dispatch runs the command through the middleware chain.
+func (b *Bot) dispatch(ctx context.Context, cmd command, message *tgbotapi.Message) error {
+	handler := cmd.handle
+	for i := len(b.middlewares) - 1; i >= 0; i-- {
+		handler = b.middlewares[i](cmd, handler)
+	}
+	err := handler(ctx, message)
+	var rejected rejection
+	if errors.As(err, &rejected) {
+		return nil
+	}
+	return err
+}
+
+func (b *Bot) withLogging(cmd command, next commandHandler) commandHandler {
+	return func(ctx context.Context, message *tgbotapi.Message) error {
+		fromID := senderID(message)
+		b.log.Info("command received", "command", cmd.Name, "chat_id", message.Chat.ID, "from_id", fromID, "message_id", message.MessageID)
+		err := next(ctx, message)
+		var rejected rejection
+		switch {
+		case errors.As(err, &rejected):
+			b.log.Info("command rejected", "command", cmd.Name, "chat_id", message.Chat.ID, "from_id", fromID, "reason", rejected.reason)
+		case err != nil:
+			b.log.Error("command failed", "command", cmd.Name, "chat_id", message.Chat.ID, "from_id", fromID, "error", err)
+		default:
+			b.log.Info("command handled", "command", cmd.Name, "chat_id", message.Chat.ID, "from_id", fromID)
+		}
+		return err
+	}
+}
+
+func (b *Bot) withRateLimit(cmd command, next commandHandler) commandHandler {
+	return func(ctx context.Context, message *tgbotapi.Message) error {
+		if !b.limiter.Allow(senderID(message)) {
+			return b.reject(message, rejectedRateLimit, "Слишком много команд. Подождите минуту.")
+		}
+		return next(ctx, message)
+	}
+}
+
+func (b *Bot) withChatScope(cmd command, next commandHandler) commandHandler {
+	return func(ctx context.Context, message *tgbotapi.Message) error {
+		if cmd.Scope == scopePrivate && !message.Chat.IsPrivate() {
+			return b.reject(message, rejectedChat, "Эта команда работает только в личных сообщениях с ботом.")
+		}
+		return next(ctx, message)
+	}
+}
+
+func (b *Bot) withAuth(cmd command, next commandHandler) commandHandler {
+	if cmd.MinRole == "" {
+		return next
+	}
+	return func(ctx context.Context, message *tgbotapi.Message) error {
+		allowed, err := b.hasRole(ctx, senderID(message), cmd.MinRole)
+		if err == nil && !allowed && cmd.Bootstrap {
+			var hasAnyAdmin bool
+			hasAnyAdmin, err = b.store.HasAnyAdmin(ctx)
+			allowed = !hasAnyAdmin
+		}
+		if err != nil {
+			_ = b.reply(message.Chat.ID, "Не удалось проверить права.")
+			return err
+		}
+		if !allowed {
+			return b.reject(message, rejectedRole, "Недостаточно прав.")
+		}
+		return next(ctx, message)
+	}
+}
+
+func (b *Bot) withArgs(cmd command, next commandHandler) commandHandler {
+	if cmd.MinArgs == 0 {
+		return next
+	}
+	return func(ctx context.Context, message *tgbotapi.Message) error {
+		args := strings.Fields(message.CommandArguments())
+		if len(args) == 0 && len(cmd.Steps) > 0 {
+			return b.startDialog(ctx, message.Chat, message.From, cmd.Name)
+		}
+		if len(args) < cmd.MinArgs {
+			return b.reject(message, rejectedArgs, "Формат: /"+cmd.Name+" "+cmd.Usage)
+		}
+		return next(ctx, message)
+	}
+}
+
+// reject replies to the user and stops the chain.
+func (b *Bot) reject(message *tgbotapi.Message, reason, text string) error {
+	if err := b.reply(message.Chat.ID, text); err != nil {
+		return err
+	}
+	return rejection{reason: reason}
+}
+
+func senderID(message *tgbotapi.Message) int64 {
+	if message.From == nil {
+		return 0
+	}
+	return message.From.ID
+}
@@ -0,0 +1,370 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	callbackDialog = "dialog"
+
+	// skipAnswer skips an optional step.
+	skipAnswer = "-"
+
+	maxAnswerLength = 500
+
+	playerHint = "\nИмя персонажа, @username, ссылка на профиль или пересланное сообщение игрока."
+)
+
+// dialogStep is one question of a dialog.
+type dialogStep struct {
+	Name     string
+	Question string
+	// Choices are offered as buttons; the answer may also be typed.
+	Choices func(ctx context.Context) []string
+	// Optional steps can be skipped with skipAnswer or the button.
+	Optional bool
+	// Photo steps take the largest size of a photo instead of text.
+	Photo bool
+	// Player steps also accept a forwarded message of the player.
+	Player bool
+	// Check validates an answer and returns its normalized value. The error
+	// text is shown to the user.
+	Check func(ctx context.Context, answer string) (string, error)
+}
+
+// dialog is a multi-step conversation. Its state lives in dialog_states, so
+// any replica can continue it after a restart.
+type dialog struct {
+	Name  string
+	Steps []dialogStep
+	// Current describes the value a step already has, if any.
+	Current func(ctx context.Context, from *tgbotapi.User, step string) string
+	// Finish runs after the last step with the answers by step name; skipped
+	// steps are absent.
+	Finish func(ctx context.Context, chat *tgbotapi.Chat, from *tgbotapi.User, data map[string]string) error
+}
+
+func (d dialog) step(name string) (dialogStep, int, bool) {
+	for i, s := range d.Steps {
+		if s.Name == name {
+			return s, i, true
+		}
+	}
+	return dialogStep{}, 0, false
+}
+
+func (b *Bot) startDialog(ctx context.Context, chat *tgbotapi.Chat, from *tgbotapi.User, name string) error {
+	d := b.dialogs[name]
+	state := db.DialogState{TelegramID: from.ID, Dialog: name, Step: d.Steps[0].Name}
+	if err := b.store.SaveDialog(ctx, state); err != nil {
+		return b.reply(chat.ID, "Не удалось начать диалог.")
+	}
+	return b.askStep(ctx, chat.ID, from, d, d.Steps[0])
+}
+
+func (b *Bot) handleCancel(ctx context.Context, message *tgbotapi.Message) error {
+	if _, err := b.store.GetDialog(ctx, message.From.ID); errors.Is(err, db.ErrNoDialog) {
+		return b.reply(message.Chat.ID, "Нечего отменять.")
+	}
+	if err := b.store.DeleteDialog(ctx, message.From.ID); err != nil {
+		return b.reply(message.Chat.ID, "Не удалось отменить.")
+	}
+	return b.reply(message.Chat.ID, "Отменено, ничего не изменилось.")
+}
+
+// continueDialog feeds a plain (non-command) message of a private chat into
+// the user's dialog, if any.
+func (b *Bot) continueDialog(ctx context.Context, message *tgbotapi.Message) error {
+	if message.From == nil || !message.Chat.IsPrivate() {
+		return nil
+	}
+	state, err := b.store.GetDialog(ctx, message.From.ID)
+	if errors.Is(err, db.ErrNoDialog) {
+		b.log.Info("non-command message ignored", "chat_id", message.Chat.ID, "message_id", message.MessageID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d, ok := b.dialogs[state.Dialog]
+	if !ok {
+		return b.store.DeleteDialog(ctx, message.From.ID)
+	}
+	step, _, ok := d.step(state.Step)
+	if !ok {
+		return b.store.DeleteDialog(ctx, message.From.ID)
+	}
+
+	answer := strings.TrimSpace(message.Text)
+	if step.Player && isForward(message) {
+		telegramID, err := b.resolveTarget(ctx, message, "")
+		if err != nil {
+			return b.reply(message.Chat.ID, err.Error())
+		}
+		answer = strconv.FormatInt(telegramID, 10)
+	}
+	if step.Photo && answer != skipAnswer {
+		answer = ""
+		if len(message.Photo) > 0 {
+			answer = message.Photo[len(message.Photo)-1].FileID
+		}
+	}
+	return b.answerStep(ctx, message.Chat, message.From, d, state, step, answer)
+}
+
+// handleDialogCallback handles the buttons of a dialog step:
+// dialog:<step>:<choice index>, dialog:<step>:skip and dialog:<step>:=<answer>.
+func (b *Bot) handleDialogCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, parts []string) error {
+	if callback.Message == nil || len(parts) < 3 {
+		return b.answerCallback(callback.ID, "Некорректный запрос.")
+	}
+	state, err := b.store.GetDialog(ctx, callback.From.ID)
+	if err != nil {
+		return b.answerCallback(callback.ID, "Диалог уже завершен.")
+	}
+	d, ok := b.dialogs[state.Dialog]
+	if !ok || state.Step != parts[1] {
+		return b.answerCallback(callback.ID, "Этот шаг уже пройден.")
+	}
+	step, _, ok := d.step(state.Step)
+	if !ok {
+		return b.answerCallback(callback.ID, "Этот шаг уже пройден.")
+	}
+
+	answer := skipAnswer
+	if value, ok := strings.CutPrefix(parts[2], "="); ok {
+		answer = value
+	} else if parts[2] != "skip" {
+		var choices []string
+		if step.Choices != nil {
+			choices = step.Choices(ctx)
+		}
+		index, err := strconv.Atoi(parts[2])
+		if err != nil || index < 0 || index >= len(choices) {
+			return b.answerCallback(callback.ID, "Неизвестный вариант.")
+		}
+		answer = choices[index]
+	}
+	if err := b.answerCallback(callback.ID, ""); err != nil {
+		return err
+	}
+	return b.answerStep(ctx, callback.Message.Chat, callback.From, d, state, step, answer)
+}
+
+// answerStep stores the answer and asks the next question. After the last
+// step the dialog is removed before Finish runs, so a failing Finish is not
+// repeated by the next message.
+func (b *Bot) answerStep(ctx context.Context, chat *tgbotapi.Chat, from *tgbotapi.User, d dialog, state db.DialogState, step dialogStep, answer string) error {
+	if answer == skipAnswer && step.Optional {
+		delete(state.Data, step.Name)
+	} else {
+		if step.Check != nil {
+			value, err := step.Check(ctx, answer)
+			var ambiguous ambiguousPlayer
+			if errors.As(err, &ambiguous) {
+				msg := tgbotapi.NewMessage(chat.ID, err.Error())
+				msg.ReplyMarkup = b.candidateButtons(ctx, ambiguous.candidates, func(player db.Player) string {
+					return fmt.Sprintf("%s:%s:=%d", callbackDialog, step.Name, player.Telegram)
+				})
+				_, err := b.api.Send(msg)
+				return err
+			}
+			if err != nil {
+				return b.reply(chat.ID, err.Error())
+			}
+			answer = value
+		}
+		if state.Data == nil {
+			state.Data = map[string]string{}
+		}
+		state.Data[step.Name] = answer
+	}
+
+	if _, i, _ := d.step(step.Name); i+1 < len(d.Steps) {
+		next := d.Steps[i+1]
+		state.Step = next.Name
+		if err := b.store.SaveDialog(ctx, state); err != nil {
+			return b.reply(chat.ID, "Не удалось сохранить ответ.")
+		}
+		return b.askStep(ctx, chat.ID, from, d, next)
+	}
+	if err := b.store.DeleteDialog(ctx, from.ID); err != nil {
+		return b.reply(chat.ID, "Не удалось завершить диалог.")
+	}
+	return d.Finish(ctx, chat, from, state.Data)
+}
+
+func (b *Bot) askStep(ctx context.Context, chatID int64, from *tgbotapi.User, d dialog, step dialogStep) error {
+	question := step.Question
+	if d.Current != nil {
+		if current := d.Current(ctx, from, step.Name); current != "" {
+			question += "\nСейчас: " + current
+		}
+	}
+	if step.Optional {
+		question += "\n«-» — пропустить, /cancel — прервать."
+	} else {
+		question += "\n/cancel — прервать."
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if step.Choices != nil {
+		var row []tgbotapi.InlineKeyboardButton
+		for i, choice := range step.Choices(ctx) {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(choice, fmt.Sprintf("%s:%s:%d", callbackDialog, step.Name, i)))
+			if len(row) == 3 {
+				rows = append(rows, row)
+				row = nil
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	if step.Optional {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Пропустить", fmt.Sprintf("%s:%s:skip", callbackDialog, step.Name))))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, question)
+	if len(rows) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	_, err := b.api.Send(msg)
+	return err
+}
+
+// commandDialog asks the arguments of cmd one by one and then runs the
+// command as if it was typed in one line, through the whole middleware chain.
+func (b *Bot) commandDialog(cmd command) dialog {
+	return dialog{
+		Name:  cmd.Name,
+		Steps: cmd.Steps,
+		Finish: func(ctx context.Context, chat *tgbotapi.Chat, from *tgbotapi.User, data map[string]string) error {
+			args := make([]string, 0, len(cmd.Steps))
+			for _, step := range cmd.Steps {
+				args = append(args, data[step.Name])
+			}
+			return b.dispatch(ctx, cmd, commandMessage(chat, from, cmd.Name, args...))
+		},
+	}
+}
+
+// commandSteps are the questions of commands that start a dialog when called
+// without arguments. Optional steps must come last.
+func (b *Bot) commandSteps() map[string][]dialogStep {
+	levels := staticChoices("1", "2", "3", "4", "5")
+	return map[string][]dialogStep{
+		"transfer": {
+			{Name: "player", Question: "Кому перевести рейтинг?" + playerHint, Player: true, Check: b.checkPlayer},
+			{Name: "amount", Question: "Сколько перевести?", Check: checkAtLeast(1)},
+		},
+		"add_player": {
+			{Name: "player", Question: "Кого добавить? Пришлите telegram_id или перешлите сообщение игрока.", Player: true, Check: b.checkPlayer},
+			{Name: "full_name", Question: "Полное имя игрока?", Check: checkText(maxNameLength)},
+		},
+		"refund_ratings": {
+			{Name: "player", Question: "Кому вернуть оценки?" + playerHint, Player: true, Check: b.checkPlayer},
+			{Name: "count", Question: "Сколько оценок вернуть?", Check: checkAtLeast(1)},
+		},
+		"set_rating_limits": {
+			{Name: "level", Question: "Для какого уровня?", Choices: levels, Check: checkChoice(levels)},
+			{Name: "limit", Question: "Сколько оценок за цикл?", Check: checkAtLeast(1)},
+			{Name: "scope", Question: "С какого цикла? current — с текущего, next — со следующего.", Optional: true,
+				Choices: staticChoices(limitScopeNext, limitScopeCurrent), Check: checkChoice(staticChoices(limitScopeNext, limitScopeCurrent))},
+		},
+		"set_level_boundary": {
+			{Name: "level", Question: "Границы какого уровня изменить?", Choices: levels, Check: checkChoice(levels)},
+			{Name: "min", Question: "Минимальный рейтинг уровня?", Check: checkInteger},
+			{Name: "max", Question: "Максимальный рейтинг уровня?", Check: checkInteger},
+		},
+		"adjust_rating": {
+			{Name: "player", Question: "Чей рейтинг изменить?" + playerHint, Player: true, Check: b.checkPlayer},
+			{Name: "delta", Question: "На сколько изменить? Например, 50 или -30.", Check: checkInteger},
+			{Name: "reason", Question: "Причина?", Check: checkText(maxAnswerLength)},
+		},
+		"set_role": {
+			{Name: "player", Question: "Чью роль изменить?" + playerHint, Player: true, Check: b.checkPlayer},
+			{Name: "role", Question: "Новая роль?", Choices: staticChoices(db.RolePlayer, db.RoleModerator, db.RoleAdmin, db.RoleSuperAdmin),
+				Check: checkChoice(staticChoices(db.RolePlayer, db.RoleModerator, db.RoleAdmin, db.RoleSuperAdmin))},
+		},
+		"set_faction": {
+			{Name: "player", Question: "Кого включить во фракцию?" + playerHint, Player: true, Check: b.checkPlayer},
+			{Name: "faction", Question: "Какая фракция? «-» — исключить из фракции.", Choices: b.factionChoices,
+				Check: func(ctx context.Context, answer string) (string, error) {
+					if answer == skipAnswer {
+						return answer, nil
+					}
+					return checkChoice(b.factionChoices)(ctx, answer)
+				}},
+		},
+	}
+}
+
+func (b *Bot) factionChoices(ctx context.Context) []string {
+	factions, err := b.store.ListFactions(ctx)
+	if err != nil {
+		b.log.Error("list factions", "error", err)
+		return nil
+	}
+	names := make([]string, 0, len(factions))
+	for _, f := range factions {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func staticChoices(choices ...string) func(context.Context) []string {
+	return func(context.Context) []string { return choices }
+}
+
+// checkChoice accepts one of the choices, ignoring case.
+func checkChoice(choices func(context.Context) []string) func(context.Context, string) (string, error) {
+	return func(ctx context.Context, answer string) (string, error) {
+		options := choices(ctx)
+		for _, choice := range options {
+			if strings.EqualFold(choice, answer) {
+				return choice, nil
+			}
+		}
+		return "", errors.New("Выберите вариант кнопкой или напишите один из: " + strings.Join(options, ", "))
+	}
+}
+
+func checkText(maxLength int) func(context.Context, string) (string, error) {
+	return func(_ context.Context, answer string) (string, error) {
+		if answer == "" {
+			return "", errors.New("Отправьте текст ответом на вопрос.")
+		}
+		if utf8.RuneCountInString(answer) > maxLength {
+			return "", fmt.Errorf("Не длиннее %d символов.", maxLength)
+		}
+		return answer, nil
+	}
+}
+
+func checkInteger(_ context.Context, answer string) (string, error) {
+	value, err := strconv.Atoi(answer)
+	if err != nil {
+		return "", errors.New("Введите целое число.")
+	}
+	return strconv.Itoa(value), nil
+}
+
+func checkAtLeast(min int) func(context.Context, string) (string, error) {
+	return func(_ context.Context, answer string) (string, error) {
+		value, err := strconv.Atoi(answer)
+		if err != nil || value < min {
+			return "", fmt.Errorf("Введите целое число не меньше %d.", min)
+		}
+		return answer, nil
+	}
+}
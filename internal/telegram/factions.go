@@ -0,0 +1,183 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// handleFactions shows the faction leaderboard of the current cycle.
+func (b *Bot) handleFactions(ctx context.Context, message *tgbotapi.Message) error {
+	cfg, err := b.store.GetSystemConfig(ctx)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить настройки.")
+	}
+	cycle, err := b.store.EnsureActiveCycle(ctx, cfg)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить цикл.")
+	}
+	standings, err := b.store.FactionLeaderboard(ctx, cycle.ID)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить рейтинг фракций.")
+	}
+	if len(standings) == 0 {
+		return b.reply(message.Chat.ID, "Фракций пока нет.")
+	}
+
+	viewer, err := b.ensurePlayer(ctx, message.From)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось определить игрока.")
+	}
+	showNumbers := showLeaderboardNumbers(cfg, viewer)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Фракции, цикл %d:\n", cycle.CycleNumber)
+	for i, st := range standings {
+		if !showNumbers {
+			fmt.Fprintf(&sb, "%d. %s, игроков: %d\n", i+1, st.Name, st.Members)
+			continue
+		}
+		fmt.Fprintf(&sb, "%d. %s — %+d за цикл (%+.1f на игрока), средний рейтинг %.0f, игроков: %d\n",
+			i+1, st.Name, st.CycleChange, st.AverageChange(), st.AverageRating, st.Members)
+	}
+	sb.WriteString("\n" + formatFactionRules(cfg.Factions))
+	return b.reply(message.Chat.ID, sb.String())
+}
+
+func (b *Bot) handleCreateFaction(ctx context.Context, message *tgbotapi.Message) error {
+	name, description, _ := strings.Cut(message.CommandArguments(), "|")
+	name, description = strings.TrimSpace(name), strings.TrimSpace(description)
+	if name == "" || len([]rune(name)) > 100 {
+		return b.reply(message.Chat.ID, "Формат: /create_faction <название> [| описание]")
+	}
+	var faction db.Faction
+	err := b.audit(ctx, message.From, db.ActionCreateFaction, func(tx *db.Store, action *db.AdminAction) error {
+		var err error
+		if faction, err = tx.CreateFaction(ctx, name, description); err != nil {
+			return err
+		}
+		action.Details = map[string]any{
+			"faction_id":  faction.ID,
+			"name":        faction.Name,
+			"description": faction.Description,
+		}
+		return nil
+	})
+	if errors.Is(err, db.ErrFactionExists) {
+		return b.reply(message.Chat.ID, "Такая фракция уже есть.")
+	}
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось создать фракцию.")
+	}
+	return b.reply(message.Chat.ID, fmt.Sprintf("Фракция создана: %s", faction.Name))
+}
+
+func (b *Bot) handleSetFaction(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) < 2 {
+		return b.reply(message.Chat.ID, "Формат: /set_faction <игрок> <фракция или ->")
+	}
+	telegramID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Некорректный telegram_id.")
+	}
+	player, err := b.store.GetPlayerByTelegramID(ctx, telegramID)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Игрок не найден.")
+	}
+
+	var factionID *int
+	newName := ""
+	if name := strings.Join(args[1:], " "); name != "-" {
+		faction, err := b.store.GetFactionByName(ctx, name)
+		if errors.Is(err, db.ErrFactionNotFound) {
+			return b.reply(message.Chat.ID, "Фракция не найдена. Создайте ее: /create_faction <название>")
+		}
+		if err != nil {
+			return b.reply(message.Chat.ID, "Не удалось найти фракцию.")
+		}
+		factionID, newName = &faction.ID, faction.Name
+	}
+
+	err = b.audit(ctx, message.From, db.ActionSetPlayerFaction, func(tx *db.Store, action *db.AdminAction) error {
+		current, err := tx.GetPlayerByID(ctx, player.ID)
+		if err != nil {
+			return err
+		}
+		action.TargetPlayerID = &player.ID
+		action.Details = map[string]any{
+			"old": factionName(ctx, tx, current.FactionID),
+			"new": newName,
+		}
+		return tx.SetPlayerFaction(ctx, player.ID, factionID)
+	})
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось сменить фракцию.")
+	}
+	if newName == "" {
+		return b.reply(message.Chat.ID, fmt.Sprintf("Игрок %s больше не состоит во фракции.", player.FullName))
+	}
+	return b.reply(message.Chat.ID, fmt.Sprintf("Игрок %s теперь во фракции %s.", player.FullName, newName))
+}
+
+func (b *Bot) handleSetFactionRules(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 2 {
+		return b.reply(message.Chat.ID, "Формат: /set_faction_rules <allow|forbid> <вес межфракционных оценок>\nallow/forbid — разрешить или запретить лайки своей фракции. Например: /set_faction_rules forbid 1.5")
+	}
+	var rules db.FactionRules
+	switch strings.ToLower(args[0]) {
+	case "allow":
+	case "forbid":
+		rules.ForbidOwnLikes = true
+	default:
+		return b.reply(message.Chat.ID, "Первый параметр: allow — лайки своей фракции разрешены, forbid — запрещены.")
+	}
+	weight, err := strconv.ParseFloat(strings.Replace(args[1], ",", ".", 1), 64)
+	if err != nil || weight < 0 || weight >= 100 {
+		return b.reply(message.Chat.ID, "Вес должен быть числом от 0 до 99.99.")
+	}
+	rules.CrossWeight = weight
+
+	err = b.audit(ctx, message.From, db.ActionChangeCycleSettings, func(tx *db.Store, action *db.AdminAction) error {
+		cfg, err := tx.GetSystemConfig(ctx)
+		if err != nil {
+			return err
+		}
+		action.Details = map[string]any{
+			"setting": "faction_rules",
+			"old":     cfg.Factions,
+			"new":     rules,
+		}
+		return tx.UpdateFactionRules(ctx, rules)
+	})
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось обновить правила фракций.")
+	}
+	return b.reply(message.Chat.ID, "Правила обновлены. "+formatFactionRules(rules))
+}
+
+func factionName(ctx context.Context, store *db.Store, factionID *int) string {
+	if factionID == nil {
+		return ""
+	}
+	faction, err := store.GetFaction(ctx, *factionID)
+	if err != nil {
+		return ""
+	}
+	return faction.Name
+}
+
+func formatFactionRules(rules db.FactionRules) string {
+	likes := "лайки своей фракции разрешены"
+	if rules.ForbidOwnLikes {
+		likes = "лайки своей фракции запрещены"
+	}
+	return fmt.Sprintf("Правила: %s, вес межфракционных оценок %.2f.", likes, rules.CrossWeight)
+}
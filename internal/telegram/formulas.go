@@ -0,0 +1,262 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+	"rts_for_rating_on_larp/internal/formula"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (b *Bot) handleFormulas(ctx context.Context, message *tgbotapi.Message) error {
+	saved, err := b.store.ListFormulas(ctx)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить формулы.")
+	}
+	var sb strings.Builder
+	sb.WriteString("Стратегии:\n")
+	for _, strategy := range formula.Strategies() {
+		fmt.Fprintf(&sb, "• %s — %s (%s)\n", strategy.Name(), strategy.Description(), strategy.Defaults())
+	}
+	sb.WriteString("\nСохраненные формулы:\n")
+	if len(saved) == 0 {
+		sb.WriteString("нет\n")
+	}
+	for _, f := range saved {
+		fmt.Fprintf(&sb, "• %s\n", formatFormula(f))
+	}
+	if cycle, err := b.store.GetActiveCycle(ctx); err == nil {
+		if current, err := b.store.GetCycleFormula(ctx, cycle.ID); err == nil {
+			fmt.Fprintf(&sb, "\nТекущий цикл %d: %s", cycle.CycleNumber, formatFormula(current))
+		}
+	}
+	if next, err := b.store.GetNextCycleFormula(ctx); err == nil {
+		fmt.Fprintf(&sb, "\nСо следующего цикла: %s", formatFormula(next))
+	}
+	return b.reply(message.Chat.ID, sb.String())
+}
+
+func (b *Bot) handleSaveFormula(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) < 2 {
+		return b.reply(message.Chat.ID, "Формат: /save_formula <имя> <стратегия> [параметр=значение ...]")
+	}
+	name := args[0]
+	if strings.Contains(name, "@") {
+		return b.reply(message.Chat.ID, "Имя формулы не должно содержать @.")
+	}
+	params, err := formula.ParseParams(args[2:])
+	if err != nil {
+		return b.reply(message.Chat.ID, "Параметры задаются как имя=число.")
+	}
+	candidate := formula.Formula{Strategy: args[1], Params: params}
+	if err := candidate.Validate(); err != nil {
+		return b.reply(message.Chat.ID, fmt.Sprintf("Формула некорректна: %v. Список стратегий: /formulas", err))
+	}
+	var saved db.RatingFormula
+	err = b.audit(ctx, message.From, db.ActionChangeRatingFormula, func(tx *db.Store, action *db.AdminAction) error {
+		var err error
+		if saved, err = tx.SaveFormula(ctx, name, candidate.Strategy, candidate.Params, ""); err != nil {
+			return err
+		}
+		action.Details = map[string]any{
+			"operation": "save",
+			"new":       formatFormula(saved),
+		}
+		return nil
+	})
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось сохранить формулу.")
+	}
+	return b.reply(message.Chat.ID, "Формула сохранена: "+formatFormula(saved))
+}
+
+func (b *Bot) handleUseFormula(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 1 && len(args) != 2 {
+		return b.reply(message.Chat.ID, "Формат: /use_formula <имя>[@версия] [current|next]")
+	}
+	name, version, err := parseFormulaRef(args[0])
+	if err != nil {
+		return b.reply(message.Chat.ID, "Некорректная версия формулы.")
+	}
+	selected, err := b.store.GetFormula(ctx, name, version)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Формула не найдена.")
+	}
+	scope := limitScopeNext
+	if len(args) == 2 {
+		scope = args[1]
+	}
+	switch scope {
+	case limitScopeNext:
+		err := b.audit(ctx, message.From, db.ActionChangeRatingFormula, func(tx *db.Store, action *db.AdminAction) error {
+			previous, err := tx.GetNextCycleFormula(ctx)
+			if err != nil {
+				return err
+			}
+			action.Details = map[string]any{
+				"scope": scope,
+				"old":   formatFormula(previous),
+				"new":   formatFormula(selected),
+			}
+			return tx.SetNextCycleFormula(ctx, selected.ID)
+		})
+		if err != nil {
+			return b.reply(message.Chat.ID, "Не удалось выбрать формулу.")
+		}
+		return b.reply(message.Chat.ID, "Со следующего цикла: "+formatFormula(selected))
+	case limitScopeCurrent:
+		cfg, err := b.store.GetSystemConfig(ctx)
+		if err != nil {
+			return b.reply(message.Chat.ID, "Не удалось получить настройки.")
+		}
+		cycle, err := b.store.EnsureActiveCycle(ctx, cfg)
+		if err != nil {
+			return b.reply(message.Chat.ID, "Не удалось получить цикл.")
+		}
+		err = b.audit(ctx, message.From, db.ActionChangeRatingFormula, func(tx *db.Store, action *db.AdminAction) error {
+			previous, err := tx.GetCycleFormula(ctx, cycle.ID)
+			if err != nil {
+				return err
+			}
+			action.Details = map[string]any{
+				"scope":        scope,
+				"cycle_number": cycle.CycleNumber,
+				"old":          formatFormula(previous),
+				"new":          formatFormula(selected),
+			}
+			return tx.SetCycleFormula(ctx, cycle.ID, selected.ID)
+		})
+		if err != nil {
+			return b.reply(message.Chat.ID, "Не удалось выбрать формулу.")
+		}
+		return b.reply(message.Chat.ID, fmt.Sprintf("В текущем цикле %d: %s", cycle.CycleNumber, formatFormula(selected)))
+	default:
+		return b.reply(message.Chat.ID, "Укажите current (текущий цикл) или next (со следующего цикла).")
+	}
+}
+
+func (b *Bot) handleFormulaDryRun(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 3 && len(args) != 5 {
+		return b.reply(message.Chat.ID, "Формат: /formula_dry_run <имя[@версия]|стратегия> <уровень оценивающего> <уровень оцениваемого> [рейтинг оценивающего] [рейтинг оцениваемого]")
+	}
+	candidate, label, err := b.resolveFormula(ctx, args[0])
+	if err != nil {
+		return b.reply(message.Chat.ID, "Формула или стратегия не найдена.")
+	}
+	numbers := make([]int, 0, 4)
+	for _, arg := range args[1:] {
+		value, err := strconv.Atoi(arg)
+		if err != nil {
+			return b.reply(message.Chat.ID, "Уровни и рейтинги должны быть целыми числами.")
+		}
+		numbers = append(numbers, value)
+	}
+	in := formula.Input{RaterLevel: numbers[0], RatedLevel: numbers[1], RaterRating: db.InitialRating, RatedRating: db.InitialRating}
+	if len(numbers) == 4 {
+		in.RaterRating, in.RatedRating = numbers[2], numbers[3]
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\nУровни %d → %d, рейтинги %d → %d\n", label, in.RaterLevel, in.RatedLevel, in.RaterRating, in.RatedRating)
+	for _, sample := range []struct {
+		name string
+		sign float64
+	}{{"Лайк", 1}, {"Дизлайк", -1}} {
+		in.Sign = sample.sign
+		result, err := candidate.Calculate(in, 1)
+		if err != nil {
+			fmt.Fprintf(&sb, "%s: ошибка (%v)\n", sample.name, err)
+			continue
+		}
+		fmt.Fprintf(&sb, "%s: %.4f → %+d\n", sample.name, result.RawValue, result.Value)
+	}
+	return b.reply(message.Chat.ID, strings.TrimRight(sb.String(), "\n"))
+}
+
+// resolveFormula looks ref up as a saved formula first and as a bare strategy
+// with default parameters second.
+func (b *Bot) resolveFormula(ctx context.Context, ref string) (formula.Formula, string, error) {
+	name, version, err := parseFormulaRef(ref)
+	if err != nil {
+		return formula.Formula{}, "", err
+	}
+	if saved, err := b.store.GetFormula(ctx, name, version); err == nil {
+		return formula.Formula{Strategy: saved.Strategy, Params: saved.Params}, formatFormula(saved), nil
+	}
+	if strategy, ok := formula.Lookup(ref); ok {
+		return formula.Formula{Strategy: strategy.Name()}, fmt.Sprintf("%s (%s)", strategy.Name(), strategy.Defaults()), nil
+	}
+	return formula.Formula{}, "", errors.New("formula not found")
+}
+
+// calculateRatingChange evaluates the cycle formula for the locked players and
+// returns the full breakdown stored with the rating.
+func calculateRatingChange(in db.RatingInput, f db.RatingFormula, cfg db.SystemConfig, ratingType string) (db.RatingCalculation, error) {
+	sign := 1.0
+	if ratingType == "dislike" {
+		sign = -1.0
+	}
+	// The faction weight scales the value like the repeat penalty does, so a
+	// weight below one may round a cross-faction rating down to zero.
+	weight := cfg.Factions.Weight(in.Rater, in.Rated)
+	candidate := formula.Formula{Strategy: f.Strategy, Params: f.Params}
+	result, err := candidate.Calculate(formula.Input{
+		RaterLevel:  in.Rater.Level,
+		RatedLevel:  in.Rated.Level,
+		RaterRating: in.Rater.Rating,
+		RatedRating: in.Rated.Rating,
+		Sign:        sign,
+	}, in.PenaltyCoefficient*weight)
+	if err != nil {
+		return db.RatingCalculation{}, err
+	}
+	calculation := db.RatingCalculation{
+		RaterLevel:         in.Rater.Level,
+		RatedLevel:         in.Rated.Level,
+		RaterRating:        in.Rater.Rating,
+		RatedRating:        in.Rated.Rating,
+		Formula:            f.Name,
+		FormulaVersion:     f.Version,
+		Strategy:           f.Strategy,
+		Params:             candidate.Resolved(),
+		RawValue:           result.RawValue,
+		PreviousRatings:    in.PreviousRatings,
+		PenaltyCoefficient: in.PenaltyCoefficient,
+		PenalizedValue:     result.PenalizedValue,
+		Rounded:            result.Rounded,
+		MinimumApplied:     result.MinimumApplied,
+		Result:             result.Value,
+		ConfigVersion:      cfg.Version,
+	}
+	if weight != 1 {
+		calculation.FactionWeight = &weight
+	}
+	return calculation, nil
+}
+
+func parseFormulaRef(ref string) (string, int, error) {
+	name, versionText, ok := strings.Cut(ref, "@")
+	if !ok {
+		return name, 0, nil
+	}
+	version, err := strconv.Atoi(versionText)
+	if err != nil || version <= 0 {
+		return "", 0, errors.New("invalid formula version")
+	}
+	return name, version, nil
+}
+
+func formatFormula(f db.RatingFormula) string {
+	if f.Version == 0 {
+		return fmt.Sprintf("%s (%s %s)", f.Name, f.Strategy, f.Params)
+	}
+	return fmt.Sprintf("%s v%d (%s %s)", f.Name, f.Version, f.Strategy, f.Params)
+}
@@ -0,0 +1,37 @@
+package telegram
+
+import "testing"
+
+func TestParseFormulaRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		name    string
+		version int
+		wantErr bool
+	}{
+		{ref: "standard", name: "standard"},
+		{ref: "standard@3", name: "standard", version: 3},
+		{ref: "level_ratio", name: "level_ratio"},
+		{ref: "standard@0", wantErr: true},
+		{ref: "standard@-1", wantErr: true},
+		{ref: "standard@", wantErr: true},
+		{ref: "standard@v2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			name, version, err := parseFormulaRef(tt.ref)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseFormulaRef(%q) succeeded, want an error", tt.ref)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFormulaRef(%q): %v", tt.ref, err)
+			}
+			if name != tt.name || version != tt.version {
+				t.Errorf("parseFormulaRef(%q) = %q, %d, want %q, %d", tt.ref, name, version, tt.name, tt.version)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package telegram
+
+import (
+	"context"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// helpSections groups /help by the minimum role of the commands.
+var helpSections = []struct {
+	role  string
+	title string
+}{
+	{"", "Команды"},
+	{db.RoleModerator, "Модератор"},
+	{db.RoleAdmin, "Администратор"},
+}
+
+// handleHelp lists the commands the sender may use in this chat.
+func (b *Bot) handleHelp(ctx context.Context, message *tgbotapi.Message) error {
+	role, err := b.store.GetPlayerRole(ctx, senderID(message))
+	if err != nil {
+		role = db.RolePlayer
+	}
+	group := !message.Chat.IsPrivate()
+
+	var sb strings.Builder
+	for _, section := range helpSections {
+		var lines []string
+		for _, cmd := range b.availableCommands(role, group) {
+			if cmd.MinRole != section.role {
+				continue
+			}
+			line := "/" + cmd.Name
+			if cmd.Usage != "" {
+				line += " " + cmd.Usage
+			}
+			lines = append(lines, line+" — "+cmd.Description)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		sb.WriteString(section.title + ":\n" + strings.Join(lines, "\n") + "\n\n")
+	}
+	if group {
+		sb.WriteString("Остальные команды — в личных сообщениях с ботом.")
+	}
+	return b.reply(message.Chat.ID, strings.TrimRight(sb.String(), "\n"))
+}
+
+// availableCommands filters the command table by role and chat type, in the
+// order of the table.
+func (b *Bot) availableCommands(role string, group bool) []command {
+	var cmds []command
+	for _, cmd := range b.commandList {
+		if cmd.MinRole != "" && !db.HasRole(role, cmd.MinRole) {
+			continue
+		}
+		if group && cmd.Scope != scopeAny {
+			continue
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+func (b *Bot) menuCommands(role string, group bool) []tgbotapi.BotCommand {
+	var menu []tgbotapi.BotCommand
+	for _, cmd := range b.availableCommands(role, group) {
+		menu = append(menu, tgbotapi.BotCommand{Command: cmd.Name, Description: cmd.Description})
+	}
+	return menu
+}
+
+// RegisterCommandMenu publishes the Telegram command menu: player commands in
+// private chats, public ones in groups, and a personal menu for every
+// moderator and admin. A personal menu that fails is logged and skipped, so
+// one blocked chat does not leave the other staff without theirs.
+func (b *Bot) RegisterCommandMenu(ctx context.Context) error {
+	configs := []tgbotapi.SetMyCommandsConfig{
+		tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeAllPrivateChats(), b.menuCommands(db.RolePlayer, false)...),
+		tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeAllGroupChats(), b.menuCommands(db.RolePlayer, true)...),
+	}
+	for _, config := range configs {
+		if _, err := b.api.Request(config); err != nil {
+			return err
+		}
+	}
+	staff, err := b.store.ListStaff(ctx)
+	if err != nil {
+		return err
+	}
+	for _, player := range staff {
+		b.refreshCommandMenu(player)
+	}
+	return nil
+}
+
+// UpdateCommandMenu sets the personal command menu of the player for their
+// role. Players get the common private menu back.
+func (b *Bot) UpdateCommandMenu(player db.Player) error {
+	scope := tgbotapi.NewBotCommandScopeChat(player.Telegram)
+	if player.Role == db.RolePlayer {
+		_, err := b.api.Request(tgbotapi.NewDeleteMyCommandsWithScope(scope))
+		return err
+	}
+	_, err := b.api.Request(tgbotapi.NewSetMyCommandsWithScope(scope, b.menuCommands(player.Role, false)...))
+	return err
+}
+
+func (b *Bot) refreshCommandMenu(player db.Player) {
+	if err := b.UpdateCommandMenu(player); err != nil {
+		b.log.Error("update command menu", "telegram_id", player.Telegram, "error", err)
+	}
+}
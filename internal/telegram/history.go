@@ -0,0 +1,146 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	callbackHistory   = "history"
+	historyEventLimit = 15
+)
+
+// handleHistory shows the player's statement for the latest cycle with
+// activity; older cycles are reached with the buttons below it.
+func (b *Bot) handleHistory(ctx context.Context, message *tgbotapi.Message) error {
+	player, err := b.ensurePlayer(ctx, message.From)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось определить игрока.")
+	}
+	text, keyboard, err := b.historyPage(ctx, player, 0)
+	if err != nil {
+		return b.reply(message.Chat.ID, text)
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if keyboard != nil {
+		msg.ReplyMarkup = *keyboard
+	}
+	_, err = b.api.Send(msg)
+	return err
+}
+
+// handleHistoryCallback turns the page: history:<page>. The statement is
+// always the one of the user who pressed the button.
+func (b *Bot) handleHistoryCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, parts []string) error {
+	if callback.Message == nil {
+		return b.answerCallback(callback.ID, "Некорректный запрос.")
+	}
+	page, err := strconv.Atoi(parts[1])
+	if err != nil || page < 0 {
+		return b.answerCallback(callback.ID, "Некорректная страница.")
+	}
+	player, err := b.ensurePlayer(ctx, callback.From)
+	if err != nil {
+		return b.answerCallback(callback.ID, "Не удалось определить игрока.")
+	}
+	text, keyboard, err := b.historyPage(ctx, player, page)
+	if err != nil {
+		return b.answerCallback(callback.ID, text)
+	}
+	var edit tgbotapi.EditMessageTextConfig
+	if keyboard != nil {
+		edit = tgbotapi.NewEditMessageTextAndMarkup(callback.Message.Chat.ID, callback.Message.MessageID, text, *keyboard)
+	} else {
+		edit = tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID, text)
+	}
+	if _, err := b.api.Send(edit); err != nil {
+		return err
+	}
+	return b.answerCallback(callback.ID, "")
+}
+
+// historyPage renders a page of the statement. On error the text is the
+// message for the player.
+func (b *Bot) historyPage(ctx context.Context, player db.Player, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	history, err := b.store.PlayerHistory(ctx, player.ID, page, historyEventLimit, db.PlayerViewer(player))
+	if errors.Is(err, db.ErrNoHistory) {
+		if page == 0 {
+			return "История пока пуста: оценок, переводов и смен уровня еще не было.", nil, err
+		}
+		return "Более ранних записей нет.", nil, err
+	}
+	if err != nil {
+		return "Не удалось получить историю.", nil, err
+	}
+	return formatHistory(history), historyKeyboard(history), nil
+}
+
+func historyKeyboard(history db.HistoryPage) *tgbotapi.InlineKeyboardMarkup {
+	var row []tgbotapi.InlineKeyboardButton
+	if history.Page+1 < history.Pages {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("◀ Раньше", fmt.Sprintf("%s:%d", callbackHistory, history.Page+1)))
+	}
+	if history.Page > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Позже ▶", fmt.Sprintf("%s:%d", callbackHistory, history.Page-1)))
+	}
+	if len(row) == 0 {
+		return nil
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return &keyboard
+}
+
+// formatHistory renders a cycle of the statement. The store has already
+// removed the raters the player may not see; transfers are never anonymous.
+func formatHistory(history db.HistoryPage) string {
+	sum := history.Summary
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "История, цикл %d (%s – %s), стр. %d из %d\n\n", history.Cycle.CycleNumber,
+		history.Cycle.StartTime.Format("02.01 15:04"), history.Cycle.EndTime.Format("02.01 15:04"), history.Page+1, history.Pages)
+	fmt.Fprintf(&sb, "Лайки: %d (%+d)\nДизлайки: %d (%+d)\n", sum.Likes, sum.LikesSum, sum.Dislikes, sum.DislikesSum)
+	fmt.Fprintf(&sb, "Переводы: получено %d (+%d), отправлено %d (−%d)\n", sum.TransfersIn, sum.AmountIn, sum.TransfersOut, sum.AmountOut)
+	if sum.Adjustments > 0 {
+		fmt.Fprintf(&sb, "Корректировки администрации: %d (%+d)\n", sum.Adjustments, sum.AdjustmentsSum)
+	}
+	fmt.Fprintf(&sb, "Итого за цикл: %+d\n", sum.Total())
+
+	if len(history.Events) > 0 {
+		sb.WriteString("\nПоследние события:\n")
+	}
+	for _, e := range history.Events {
+		sb.WriteString(e.CreatedAt.Format("02.01 15:04") + " ")
+		switch e.Kind {
+		case db.HistoryLike, db.HistoryDislike:
+			kind := "👍"
+			if e.Kind == db.HistoryDislike {
+				kind = "👎"
+			}
+			rater := "аноним"
+			if e.CounterpartID != nil {
+				rater = e.CounterpartName
+			}
+			fmt.Fprintf(&sb, "%s %+d от: %s", kind, e.Amount, rater)
+		case db.HistoryTransferIn:
+			fmt.Fprintf(&sb, "перевод +%d от: %s", e.Amount, e.CounterpartName)
+		case db.HistoryTransferOut:
+			fmt.Fprintf(&sb, "перевод −%d для: %s", e.Amount, e.CounterpartName)
+		case db.HistoryAdjustment:
+			fmt.Fprintf(&sb, "корректировка %+d: %s", e.Amount, e.Reason)
+		case db.HistoryLevel:
+			if e.OldLevel != nil {
+				fmt.Fprintf(&sb, "уровень %d → %d", *e.OldLevel, e.NewLevel)
+			} else {
+				fmt.Fprintf(&sb, "уровень %d", e.NewLevel)
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
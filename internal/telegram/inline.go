@@ -0,0 +1,111 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxInlineResults = 10
+	// inlineCacheSeconds is short: results show ratings and the viewer's own
+	// card is left out.
+	inlineCacheSeconds = 10
+)
+
+// handleInlineQuery answers @bot <имя> with player cards. The card is posted
+// to a chat everyone can read, so only character names are searched, the
+// card leaves out the real name and it follows the public leaderboard rules
+// whatever the role of the sender; the buttons go through processRating like
+// the ones of a profile. Queries count against the command rate limit.
+func (b *Bot) handleInlineQuery(ctx context.Context, query *tgbotapi.InlineQuery) error {
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		CacheTime:     inlineCacheSeconds,
+		IsPersonal:    true,
+		Results:       []interface{}{},
+	}
+	// fail answers with no results and nothing cached, so the next keystroke
+	// asks again.
+	fail := func(outcome string, err error) error {
+		inlineQueriesTotal.WithLabelValues(outcome).Inc()
+		answer.CacheTime = 0
+		answer.Results = []interface{}{}
+		if _, requestErr := b.api.Request(answer); requestErr != nil {
+			return errors.Join(err, requestErr)
+		}
+		return err
+	}
+	text := strings.TrimSpace(query.Query)
+	if text == "" {
+		inlineQueriesTotal.WithLabelValues("empty").Inc()
+		_, err := b.api.Request(answer)
+		return err
+	}
+	if !b.limiter.Allow(query.From.ID) {
+		return fail(rejectedRateLimit, nil)
+	}
+
+	viewer, err := b.ensurePlayer(ctx, query.From)
+	if err != nil {
+		return fail("error", err)
+	}
+	cfg, err := b.store.GetSystemConfig(ctx)
+	if err != nil {
+		return fail("error", err)
+	}
+	players, err := b.store.SearchCharacters(ctx, text, maxInlineResults)
+	if err != nil {
+		return fail("error", err)
+	}
+	for _, target := range players {
+		if target.ID == viewer.ID {
+			continue
+		}
+		profile, err := b.store.GetCharacterProfile(ctx, target.ID)
+		if err != nil {
+			b.log.Error("get character profile", "player_id", target.ID, "error", err)
+		}
+		answer.Results = append(answer.Results, inlinePlayerResult(target, profile, !cfg.HideLeaderboardNumbers))
+	}
+	outcome := "found"
+	if len(answer.Results) == 0 {
+		outcome = "not_found"
+	}
+	inlineQueriesTotal.WithLabelValues(outcome).Inc()
+	_, err = b.api.Request(answer)
+	return err
+}
+
+// handleChosenInlineResult records which card was posted. Telegram sends it
+// only when inline feedback is enabled in @BotFather.
+func (b *Bot) handleChosenInlineResult(result *tgbotapi.ChosenInlineResult) {
+	inlineChosenTotal.Inc()
+	var fromID int64
+	if result.From != nil {
+		fromID = result.From.ID
+	}
+	b.log.Info("inline result chosen", "from_id", fromID, "player_id", result.ResultID, "query", result.Query)
+}
+
+func inlinePlayerResult(target db.Player, profile db.CharacterProfile, showNumbers bool) tgbotapi.InlineQueryResultArticle {
+	card := formatPublicCard(target, profile)
+	stats := fmt.Sprintf("Уровень: %d", target.Level)
+	if showNumbers {
+		stats += fmt.Sprintf(", рейтинг: %d", target.Rating)
+	}
+	result := tgbotapi.NewInlineQueryResultArticle(strconv.Itoa(target.ID), profile.PublicName(target), card+"\n\n"+stats)
+	result.Description = stats
+	if profile.CharacterClass != "" {
+		result.Description = profile.CharacterClass + " · " + stats
+	}
+	keyboard := profileKeyboard(target.ID)
+	result.ReplyMarkup = &keyboard
+	return result
+}
@@ -0,0 +1,172 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	defaultTopSize = 10
+	maxTopSize     = 50
+	moversSize     = 5
+)
+
+// handleTop shows the ranking: /top [N] or /top level <n> [N].
+func (b *Bot) handleTop(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	level := 0
+	if len(args) > 0 && strings.EqualFold(args[0], "level") {
+		if len(args) < 2 {
+			return b.reply(message.Chat.ID, "Формат: /top level <уровень 1-5> [N]")
+		}
+		parsed, err := strconv.Atoi(args[1])
+		if err != nil || parsed < 1 || parsed > 5 {
+			return b.reply(message.Chat.ID, "Уровень должен быть от 1 до 5.")
+		}
+		level = parsed
+		args = args[2:]
+	}
+	size := defaultTopSize
+	if len(args) > 0 {
+		parsed, err := strconv.Atoi(args[0])
+		if err != nil || parsed < 1 || parsed > maxTopSize {
+			return b.reply(message.Chat.ID, fmt.Sprintf("Формат: /top [N], N от 1 до %d; /top level <n> [N]", maxTopSize))
+		}
+		size = parsed
+	}
+
+	viewer, err := b.ensurePlayer(ctx, message.From)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось определить игрока.")
+	}
+	cfg, err := b.store.GetSystemConfig(ctx)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить настройки.")
+	}
+	showNumbers := showLeaderboardNumbers(cfg, viewer)
+	entries, err := b.store.TopPlayers(ctx, size, level)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить рейтинг.")
+	}
+	if len(entries) == 0 {
+		return b.reply(message.Chat.ID, "В рейтинге пока никого нет.")
+	}
+
+	var sb strings.Builder
+	if level > 0 {
+		fmt.Fprintf(&sb, "Топ-%d уровня %d:\n", size, level)
+	} else {
+		fmt.Fprintf(&sb, "Топ-%d:\n", size)
+	}
+	inTop := false
+	for _, entry := range entries {
+		sb.WriteString(formatLeaderboardEntry(entry, showNumbers) + "\n")
+		inTop = inTop || entry.PlayerID == viewer.ID
+	}
+	if !inTop && (level == 0 || level == viewer.Level) {
+		if own, err := b.store.PlayerRank(ctx, viewer.ID, level); err == nil {
+			sb.WriteString("…\n" + formatLeaderboardEntry(own, showNumbers) + " — это вы\n")
+		}
+	}
+	if !showNumbers {
+		sb.WriteString("\nТочные рейтинги скрыты до конца игры.")
+	}
+	return b.reply(message.Chat.ID, strings.TrimRight(sb.String(), "\n"))
+}
+
+// handleMovers shows who gained and lost the most through ratings in the
+// current cycle.
+func (b *Bot) handleMovers(ctx context.Context, message *tgbotapi.Message) error {
+	viewer, err := b.ensurePlayer(ctx, message.From)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось определить игрока.")
+	}
+	cfg, err := b.store.GetSystemConfig(ctx)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить настройки.")
+	}
+	cycle, err := b.store.EnsureActiveCycle(ctx, cfg)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить цикл.")
+	}
+	gainers, losers, err := b.store.CycleMovers(ctx, cycle.ID, moversSize)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось получить изменения рейтинга.")
+	}
+	if len(gainers) == 0 && len(losers) == 0 {
+		return b.reply(message.Chat.ID, fmt.Sprintf("В цикле %d оценок пока не было.", cycle.CycleNumber))
+	}
+	showNumbers := showLeaderboardNumbers(cfg, viewer)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Цикл %d.\n", cycle.CycleNumber)
+	if len(gainers) > 0 {
+		sb.WriteString("\nБольше всех выросли:\n")
+		for i, m := range gainers {
+			sb.WriteString(formatMover(i+1, m, showNumbers) + "\n")
+		}
+	}
+	if len(losers) > 0 {
+		sb.WriteString("\nБольше всех потеряли:\n")
+		for i, m := range losers {
+			sb.WriteString(formatMover(i+1, m, showNumbers) + "\n")
+		}
+	}
+	return b.reply(message.Chat.ID, strings.TrimRight(sb.String(), "\n"))
+}
+
+func (b *Bot) handleSetLeaderboardNumbers(ctx context.Context, message *tgbotapi.Message) error {
+	var hide bool
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "show":
+	case "hide":
+		hide = true
+	default:
+		return b.reply(message.Chat.ID, "Формат: /set_leaderboard_numbers <show|hide>")
+	}
+	err := b.audit(ctx, message.From, db.ActionChangeCycleSettings, func(tx *db.Store, action *db.AdminAction) error {
+		cfg, err := tx.GetSystemConfig(ctx)
+		if err != nil {
+			return err
+		}
+		action.Details = map[string]any{
+			"setting": "leaderboard_hide_numbers",
+			"old":     cfg.HideLeaderboardNumbers,
+			"new":     hide,
+		}
+		return tx.UpdateLeaderboardVisibility(ctx, hide)
+	})
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось обновить настройку.")
+	}
+	if hide {
+		return b.reply(message.Chat.ID, "Игроки видят в рейтингах только места и уровни.")
+	}
+	return b.reply(message.Chat.ID, "Игроки видят в рейтингах точные числа.")
+}
+
+// showLeaderboardNumbers reports whether the viewer may see exact ratings in
+// public rankings. Moderators always can.
+func showLeaderboardNumbers(cfg db.SystemConfig, viewer db.Player) bool {
+	return !cfg.HideLeaderboardNumbers || db.HasRole(viewer.Role, db.RoleModerator)
+}
+
+func formatLeaderboardEntry(entry db.LeaderboardEntry, showNumbers bool) string {
+	if !showNumbers {
+		return fmt.Sprintf("%d. %s — ур. %d", entry.Rank, entry.Name, entry.Level)
+	}
+	return fmt.Sprintf("%d. %s — ур. %d, рейтинг %d", entry.Rank, entry.Name, entry.Level, entry.Rating)
+}
+
+func formatMover(place int, m db.Mover, showNumbers bool) string {
+	if !showNumbers {
+		return fmt.Sprintf("%d. %s (ур. %d)", place, m.Name, m.Level)
+	}
+	return fmt.Sprintf("%d. %s (ур. %d): %+d", place, m.Name, m.Level, m.Change)
+}
@@ -0,0 +1,50 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	commandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bot_commands_total",
+		Help: "Bot commands by outcome: ok, error or the rejection reason.",
+	}, []string{"command", "outcome"})
+	commandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bot_command_duration_seconds",
+		Help:    "Time to handle a bot command, Telegram calls included.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"command"})
+	inlineQueriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bot_inline_queries_total",
+		Help: "Inline queries by outcome: found, not_found, empty, rate_limited or error.",
+	}, []string{"outcome"})
+	inlineChosenTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bot_inline_chosen_total",
+		Help: "Player cards posted through inline mode.",
+	})
+)
+
+func withMetrics(cmd command, next commandHandler) commandHandler {
+	return func(ctx context.Context, message *tgbotapi.Message) error {
+		start := time.Now()
+		err := next(ctx, message)
+		commandDuration.WithLabelValues(cmd.Name).Observe(time.Since(start).Seconds())
+
+		outcome := "ok"
+		var rejected rejection
+		switch {
+		case errors.As(err, &rejected):
+			outcome = rejected.reason
+		case err != nil:
+			outcome = "error"
+		}
+		commandsTotal.WithLabelValues(cmd.Name, outcome).Inc()
+		return err
+	}
+}
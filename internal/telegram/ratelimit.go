@@ -0,0 +1,55 @@
+package telegram
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	commandRateLimit  = 20
+	commandRateWindow = time.Minute
+)
+
+// rateLimiter counts commands per Telegram user in fixed windows. It is kept
+// in memory: a restart simply resets the counters.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[int64]rateWindow
+	swept   time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, windows: make(map[int64]rateWindow)}
+}
+
+// Allow counts a command of the user and reports whether it is within the
+// limit.
+func (l *rateLimiter) Allow(userID int64) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > l.window {
+		for id, w := range l.windows {
+			if now.Sub(w.start) > l.window {
+				delete(l.windows, id)
+			}
+		}
+		l.swept = now
+	}
+
+	w := l.windows[userID]
+	if now.Sub(w.start) > l.window {
+		w = rateWindow{start: now}
+	}
+	w.count++
+	l.windows[userID] = w
+	return w.count <= l.limit
+}
@@ -0,0 +1,207 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	dialogRegister = "register"
+
+	stepRealName      = "real_name"
+	stepCharacterName = "character_name"
+	stepClass         = "class"
+	stepBio           = "bio"
+	stepPhoto         = "photo"
+
+	maxNameLength = 100
+	maxBioLength  = 500
+)
+
+// handleRegister starts the profile dialog. With arguments it keeps working
+// as a one-liner that needs both the class and the name:
+// /register <класс> <имя персонажа>.
+func (b *Bot) handleRegister(ctx context.Context, message *tgbotapi.Message) error {
+	player, err := b.ensurePlayer(ctx, message.From)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось зарегистрировать игрока.")
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		return b.startDialog(ctx, message.Chat, message.From, dialogRegister)
+	}
+	if db.IsRole(args[0]) {
+		return b.reply(message.Chat.ID, "Права в системе назначают администраторы. Укажите класс персонажа: "+strings.Join(b.characterClasses, ", "))
+	}
+	class, ok := b.characterClass(args[0])
+	if !ok || len(args) < 2 {
+		return b.reply(message.Chat.ID, "Формат: /register <класс> <имя персонажа>, или /register без аргументов для пошаговой анкеты. Классы: "+strings.Join(b.characterClasses, ", "))
+	}
+
+	profile, err := b.store.GetCharacterProfile(ctx, player.ID)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Не удалось загрузить анкету.")
+	}
+	profile.CharacterClass = class
+	name := strings.Join(args[1:], " ")
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return b.reply(message.Chat.ID, fmt.Sprintf("Имя персонажа длиннее %d символов.", maxNameLength))
+	}
+	profile.CharacterName = name
+	if err := b.store.SaveCharacterProfile(ctx, profile); err != nil {
+		return b.reply(message.Chat.ID, "Не удалось обновить анкету.")
+	}
+	return b.reply(message.Chat.ID, fmt.Sprintf("Анкета обновлена: %s (%s)", name, profile.CharacterClass))
+}
+
+// registerDialog asks the profile step by step. A skipped step keeps the
+// current value; the profile is saved in one go after the last step.
+func (b *Bot) registerDialog() dialog {
+	return dialog{
+		Name: dialogRegister,
+		Steps: []dialogStep{
+			{Name: stepRealName, Question: "Как вас зовут в жизни?", Optional: true, Check: checkText(maxNameLength)},
+			{Name: stepCharacterName, Question: "Как зовут вашего персонажа?", Optional: true, Check: checkText(maxNameLength)},
+			{Name: stepClass, Question: "Выберите класс персонажа.", Optional: true,
+				Choices: func(context.Context) []string { return b.characterClasses },
+				Check:   checkChoice(func(context.Context) []string { return b.characterClasses })},
+			{Name: stepBio, Question: fmt.Sprintf("Коротко опишите персонажа (до %d символов).", maxBioLength), Optional: true, Check: checkText(maxBioLength)},
+			{Name: stepPhoto, Question: "Пришлите фотографию персонажа.", Optional: true, Photo: true,
+				Check: func(_ context.Context, answer string) (string, error) {
+					if answer == "" {
+						return "", errors.New("Отправьте фотографию или «-», чтобы пропустить шаг.")
+					}
+					return answer, nil
+				}},
+		},
+		Current: b.registerCurrent,
+		Finish:  b.saveRegistration,
+	}
+}
+
+func (b *Bot) registerCurrent(ctx context.Context, from *tgbotapi.User, step string) string {
+	player, err := b.store.GetPlayerByTelegramID(ctx, from.ID)
+	if err != nil {
+		return ""
+	}
+	profile, err := b.store.GetCharacterProfile(ctx, player.ID)
+	if err != nil {
+		return ""
+	}
+	switch step {
+	case stepRealName:
+		return player.FullName
+	case stepCharacterName:
+		return profile.CharacterName
+	case stepClass:
+		return profile.CharacterClass
+	case stepBio:
+		return profile.Bio
+	case stepPhoto:
+		if profile.PhotoFileID != "" {
+			return "есть"
+		}
+	}
+	return ""
+}
+
+func (b *Bot) saveRegistration(ctx context.Context, chat *tgbotapi.Chat, from *tgbotapi.User, data map[string]string) error {
+	player, err := b.ensurePlayer(ctx, from)
+	if err != nil {
+		return b.reply(chat.ID, "Не удалось определить игрока.")
+	}
+	profile, err := b.store.GetCharacterProfile(ctx, player.ID)
+	if err != nil {
+		return b.reply(chat.ID, "Не удалось загрузить анкету.")
+	}
+	if name, ok := data[stepRealName]; ok {
+		if err := b.store.UpdatePlayerName(ctx, player.ID, name); err != nil {
+			return b.reply(chat.ID, "Не удалось сохранить анкету.")
+		}
+		player.FullName = name
+	}
+	applyRegisterData(&profile, data)
+	if err := b.store.SaveCharacterProfile(ctx, profile); err != nil {
+		return b.reply(chat.ID, "Не удалось сохранить анкету.")
+	}
+	return b.sendCharacterCard(chat.ID, "Анкета сохранена.\n\n"+formatCharacterCard(player, profile), profile, nil)
+}
+
+func applyRegisterData(profile *db.CharacterProfile, data map[string]string) {
+	for step, value := range data {
+		switch step {
+		case stepCharacterName:
+			profile.CharacterName = value
+		case stepClass:
+			profile.CharacterClass = value
+		case stepBio:
+			profile.Bio = value
+		case stepPhoto:
+			profile.PhotoFileID = value
+		}
+	}
+}
+
+func formatCharacterCard(player db.Player, profile db.CharacterProfile) string {
+	return characterCard(player, profile, true)
+}
+
+// formatPublicCard is the character card without the real name of the
+// player, for messages anyone in a chat can read.
+func formatPublicCard(player db.Player, profile db.CharacterProfile) string {
+	return characterCard(player, profile, false)
+}
+
+func characterCard(player db.Player, profile db.CharacterProfile, realName bool) string {
+	var sb strings.Builder
+	if realName {
+		sb.WriteString(profile.DisplayName(player))
+	} else {
+		sb.WriteString(profile.PublicName(player))
+	}
+	if profile.CharacterClass != "" {
+		fmt.Fprintf(&sb, " (%s)", profile.CharacterClass)
+	}
+	if profile.Faction != "" {
+		fmt.Fprintf(&sb, "\nФракция: %s", profile.Faction)
+	}
+	if realName && profile.CharacterName != "" && profile.CharacterName != player.FullName {
+		fmt.Fprintf(&sb, "\nИгрок: %s", player.FullName)
+	}
+	if profile.Bio != "" {
+		fmt.Fprintf(&sb, "\n\n%s", profile.Bio)
+	}
+	return sb.String()
+}
+
+// sendCharacterCard sends the card as a photo caption when the character has
+// a photo, and as a plain message otherwise.
+func (b *Bot) sendCharacterCard(chatID int64, text string, profile db.CharacterProfile, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	if profile.PhotoFileID != "" && utf8.RuneCountInString(text) <= 1024 {
+		photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(profile.PhotoFileID))
+		photo.Caption = text
+		if keyboard != nil {
+			photo.ReplyMarkup = *keyboard
+		}
+		_, err := b.api.Send(photo)
+		if err == nil {
+			return nil
+		}
+		// A stale file_id should not hide the whole card.
+		b.log.Error("send character photo failed", "chat_id", chatID, "error", err)
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	if keyboard != nil {
+		msg.ReplyMarkup = *keyboard
+	}
+	_, err := b.api.Send(msg)
+	return err
+}
@@ -0,0 +1,282 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	callbackPick = "pick"
+	// dialogPick keeps a command that waits for the player to be picked from
+	// several matches.
+	dialogPick = "pick"
+
+	maxPlayerMatches  = 5
+	rejectedAmbiguous = "ambiguous_player"
+)
+
+var (
+	errPlayerNotResolved = errors.New("Игрок не найден. Укажите имя персонажа, @username, ссылку на профиль или telegram_id.")
+	errForwardHidden     = errors.New("Игрок скрыл аккаунт в пересланных сообщениях. Укажите имя персонажа или @username.")
+)
+
+// ambiguousPlayer is returned when a name matches several players.
+type ambiguousPlayer struct {
+	candidates []db.Player
+}
+
+func (a ambiguousPlayer) Error() string {
+	return "Нашлось несколько игроков, выберите нужного."
+}
+
+// refKind is what a player reference points at.
+type refKind int
+
+const (
+	refName refKind = iota
+	refTelegramID
+	refUsername
+	refLinkHash
+)
+
+// classifyPlayerRef tells what a player reference is and returns the value
+// to look it up by: the ID, the @username, the hash of a player_ link or the
+// name. Only a player_ hash or a t.me start link with one counts as a link.
+func classifyPlayerRef(ref string) (refKind, string) {
+	ref = strings.TrimSpace(ref)
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil && id > 0 {
+		return refTelegramID, ref
+	}
+	if strings.HasPrefix(ref, "@") {
+		return refUsername, ref
+	}
+	if hash, ok := strings.CutPrefix(ref, "player_"); ok && hash != "" {
+		return refLinkHash, hash
+	}
+	if hash, ok := startLinkHash(ref); ok {
+		return refLinkHash, hash
+	}
+	return refName, ref
+}
+
+// startLinkHash extracts the hash from a profile link
+// https://t.me/<bot>?start=player_<hash>; the scheme may be left out.
+func startLinkHash(ref string) (string, bool) {
+	if !strings.Contains(ref, "://") {
+		ref = "https://" + ref
+	}
+	link, err := url.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(link.Hostname()) {
+	case "t.me", "telegram.me":
+	default:
+		return "", false
+	}
+	hash, ok := strings.CutPrefix(link.Query().Get("start"), "player_")
+	return hash, ok && hash != ""
+}
+
+// resolvePlayer turns a player reference into a Telegram ID. It accepts a
+// numeric telegram_id, @username, a profile link or its player_ hash, and a
+// character or real name, fuzzy matched. A numeric ID is returned as is,
+// even for a player who has not written to the bot yet.
+func (b *Bot) resolvePlayer(ctx context.Context, ref string) (int64, error) {
+	kind, value := classifyPlayerRef(ref)
+	if kind == refTelegramID {
+		return strconv.ParseInt(value, 10, 64)
+	}
+
+	var (
+		player db.Player
+		err    error
+	)
+	switch {
+	case kind == refUsername:
+		player, err = b.store.FindPlayerByUsername(ctx, value)
+	case kind == refLinkHash:
+		player, err = b.store.GetPlayerByLinkHash(ctx, value)
+	case value != "":
+		var matches []db.Player
+		matches, err = b.store.SearchPlayers(ctx, value, maxPlayerMatches)
+		switch {
+		case err != nil:
+		case len(matches) == 0:
+			err = db.ErrPlayerNotFound
+		case len(matches) == 1:
+			player = matches[0]
+		default:
+			return 0, ambiguousPlayer{candidates: matches}
+		}
+	default:
+		err = db.ErrPlayerNotFound
+	}
+	if err != nil {
+		b.log.Info("player not resolved", "ref", ref, "error", err)
+		return 0, errPlayerNotResolved
+	}
+	return player.Telegram, nil
+}
+
+// resolveTarget finds the player of a command: the author of forward, when
+// it is a forwarded message, or the player the reference points at.
+func (b *Bot) resolveTarget(ctx context.Context, forward *tgbotapi.Message, ref string) (int64, error) {
+	if !isForward(forward) {
+		return b.resolvePlayer(ctx, ref)
+	}
+	if forward.ForwardFrom == nil {
+		return 0, errForwardHidden
+	}
+	return forward.ForwardFrom.ID, nil
+}
+
+// isForward reports whether message was forwarded from a user, including one
+// who hid the account.
+func isForward(message *tgbotapi.Message) bool {
+	return message != nil && (message.ForwardFrom != nil || message.ForwardSenderName != "")
+}
+
+// candidateButtons lists the matches of an ambiguous name, one per row.
+func (b *Bot) candidateButtons(ctx context.Context, candidates []db.Player, data func(db.Player) string) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(candidates))
+	for _, player := range candidates {
+		label := player.FullName
+		if profile, err := b.store.GetCharacterProfile(ctx, player.ID); err == nil && profile.DisplayName(player) != player.FullName {
+			label = fmt.Sprintf("%s (%s)", profile.DisplayName(player), player.FullName)
+		}
+		if player.Username != "" {
+			label += " @" + player.Username
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, data(player))))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// withTarget resolves the player reference in the first argument of commands
+// that take one, so that handlers always get a telegram_id. A command sent in
+// reply to a forwarded message targets its author and keeps all arguments.
+// When a name matches several players, the command waits for a button press.
+func (b *Bot) withTarget(cmd command, next commandHandler) commandHandler {
+	if !cmd.Target {
+		return next
+	}
+	return func(ctx context.Context, message *tgbotapi.Message) error {
+		ref, rest := splitPlayerRef(message.CommandArguments())
+		if isForward(message.ReplyToMessage) {
+			ref, rest = "", strings.TrimSpace(message.CommandArguments())
+		}
+		telegramID, err := b.resolveTarget(ctx, message.ReplyToMessage, ref)
+		var ambiguous ambiguousPlayer
+		switch {
+		case errors.As(err, &ambiguous):
+			return b.askPlayerPick(ctx, message, cmd, rest, ambiguous.candidates)
+		case err != nil:
+			return b.reject(message, rejectedArgs, err.Error())
+		}
+		return next(ctx, commandMessage(message.Chat, message.From, cmd.Name, strconv.FormatInt(telegramID, 10), rest))
+	}
+}
+
+func (b *Bot) askPlayerPick(ctx context.Context, message *tgbotapi.Message, cmd command, rest string, candidates []db.Player) error {
+	ids := make([]string, 0, len(candidates))
+	for _, player := range candidates {
+		ids = append(ids, strconv.FormatInt(player.Telegram, 10))
+	}
+	state := db.DialogState{
+		TelegramID: message.From.ID,
+		Dialog:     dialogPick,
+		Step:       cmd.Name,
+		Data:       map[string]string{"args": rest, "candidates": strings.Join(ids, ",")},
+	}
+	if err := b.store.SaveDialog(ctx, state); err != nil {
+		return b.reply(message.Chat.ID, "Не удалось сохранить выбор.")
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, ambiguousPlayer{}.Error())
+	msg.ReplyMarkup = b.candidateButtons(ctx, candidates, func(player db.Player) string {
+		return fmt.Sprintf("%s:%d", callbackPick, player.Telegram)
+	})
+	if _, err := b.api.Send(msg); err != nil {
+		return err
+	}
+	return rejection{reason: rejectedAmbiguous}
+}
+
+// handlePickCallback runs the waiting command for the chosen player:
+// pick:<telegram_id>. The command goes through the whole middleware chain
+// again.
+func (b *Bot) handlePickCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, parts []string) error {
+	if callback.Message == nil {
+		return b.answerCallback(callback.ID, "Некорректный запрос.")
+	}
+	state, err := b.store.GetDialog(ctx, callback.From.ID)
+	if err != nil || state.Dialog != dialogPick {
+		return b.answerCallback(callback.ID, "Выбор устарел, повторите команду.")
+	}
+	cmd, ok := b.commands[state.Step]
+	candidate := false
+	for _, id := range strings.Split(state.Data["candidates"], ",") {
+		candidate = candidate || id == parts[1]
+	}
+	if !ok || !candidate {
+		return b.answerCallback(callback.ID, "Выбор устарел, повторите команду.")
+	}
+	if err := b.store.DeleteDialog(ctx, callback.From.ID); err != nil {
+		return b.answerCallback(callback.ID, "Не удалось применить выбор.")
+	}
+	if err := b.answerCallback(callback.ID, ""); err != nil {
+		return err
+	}
+	return b.dispatch(ctx, cmd, commandMessage(callback.Message.Chat, callback.From, cmd.Name, parts[1], state.Data["args"]))
+}
+
+// checkPlayer is the Check of dialog steps that ask for a player.
+func (b *Bot) checkPlayer(ctx context.Context, answer string) (string, error) {
+	telegramID, err := b.resolvePlayer(ctx, answer)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(telegramID, 10), nil
+}
+
+// splitPlayerRef separates the player reference from the other arguments.
+// A name of several words is given in quotes: /transfer "Марк Антоний" 10.
+func splitPlayerRef(args string) (string, string) {
+	args = strings.TrimSpace(args)
+	for open, closing := range map[string]string{`"`: `"`, "«": "»"} {
+		if !strings.HasPrefix(args, open) {
+			continue
+		}
+		if end := strings.Index(args[len(open):], closing); end >= 0 {
+			ref := args[len(open) : len(open)+end]
+			return strings.TrimSpace(ref), strings.TrimSpace(args[len(open)+end+len(closing):])
+		}
+	}
+	ref, rest, _ := strings.Cut(args, " ")
+	return ref, strings.TrimSpace(rest)
+}
+
+// commandMessage builds a command message as if the user typed it, for
+// commands completed by a dialog or a button.
+func commandMessage(chat *tgbotapi.Chat, from *tgbotapi.User, name string, args ...string) *tgbotapi.Message {
+	text := "/" + name
+	for _, arg := range args {
+		if arg != "" {
+			text += " " + arg
+		}
+	}
+	return &tgbotapi.Message{
+		From:     from,
+		Chat:     chat,
+		Text:     text,
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(name) + 1}},
+	}
+}
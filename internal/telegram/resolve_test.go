@@ -0,0 +1,60 @@
+package telegram
+
+import "testing"
+
+func TestSplitPlayerRef(t *testing.T) {
+	tests := []struct {
+		args string
+		ref  string
+		rest string
+	}{
+		{args: "", ref: "", rest: ""},
+		{args: "123456", ref: "123456", rest: ""},
+		{args: "@marcus 10", ref: "@marcus", rest: "10"},
+		{args: "  Марк   10 причина ", ref: "Марк", rest: "10 причина"},
+		{args: `"Марк Антоний" 10`, ref: "Марк Антоний", rest: "10"},
+		{args: "«Марк Антоний» 10", ref: "Марк Антоний", rest: "10"},
+		{args: `"Марк Антоний"`, ref: "Марк Антоний", rest: ""},
+		{args: `"Марк Антоний 10`, ref: `"Марк`, rest: "Антоний 10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			ref, rest := splitPlayerRef(tt.args)
+			if ref != tt.ref || rest != tt.rest {
+				t.Errorf("splitPlayerRef(%q) = %q, %q, want %q, %q", tt.args, ref, rest, tt.ref, tt.rest)
+			}
+		})
+	}
+}
+
+func TestClassifyPlayerRef(t *testing.T) {
+	tests := []struct {
+		ref   string
+		kind  refKind
+		value string
+	}{
+		{ref: "123456", kind: refTelegramID, value: "123456"},
+		{ref: " 123456 ", kind: refTelegramID, value: "123456"},
+		{ref: "0", kind: refName, value: "0"},
+		{ref: "-5", kind: refName, value: "-5"},
+		{ref: "@marcus", kind: refUsername, value: "@marcus"},
+		{ref: "player_abc123", kind: refLinkHash, value: "abc123"},
+		{ref: "player_", kind: refName, value: "player_"},
+		{ref: "https://t.me/novy_rim_bot?start=player_abc123", kind: refLinkHash, value: "abc123"},
+		{ref: "t.me/novy_rim_bot?start=player_abc123", kind: refLinkHash, value: "abc123"},
+		{ref: "https://telegram.me/novy_rim_bot?start=player_abc123", kind: refLinkHash, value: "abc123"},
+		{ref: "https://example.com/?start=player_abc123", kind: refName, value: "https://example.com/?start=player_abc123"},
+		{ref: "https://t.me/novy_rim_bot?start=other", kind: refName, value: "https://t.me/novy_rim_bot?start=other"},
+		{ref: "old_player_name", kind: refName, value: "old_player_name"},
+		{ref: "Марк Антоний", kind: refName, value: "Марк Антоний"},
+		{ref: "", kind: refName, value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			kind, value := classifyPlayerRef(tt.ref)
+			if kind != tt.kind || value != tt.value {
+				t.Errorf("classifyPlayerRef(%q) = %d, %q, want %d, %q", tt.ref, kind, value, tt.kind, tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"rts_for_rating_on_larp/internal/db"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (b *Bot) handleSetRole(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 2 {
+		return b.reply(message.Chat.ID, "Формат: /set_role <игрок> <player|moderator|admin|super_admin>")
+	}
+	telegramID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return b.reply(message.Chat.ID, "Некорректный telegram_id.")
+	}
+	oldRole, player, err := b.changeRole(ctx, message.From, telegramID, args[1], db.ActionChangePlayerRole)
+	if err != nil {
+		return b.reply(message.Chat.ID, roleError(err, "Не удалось изменить роль.").Error())
+	}
+	b.refreshCommandMenu(player)
+	return b.reply(message.Chat.ID, fmt.Sprintf("Роль игрока %s: %s → %s.", player.FullName, oldRole, player.Role))
+}
+
+// changeRole changes a role on behalf of the sender, whose own role decides
+// what is allowed. /create_admin only promotes: it never demotes a super
+// admin.
+func (b *Bot) changeRole(ctx context.Context, from *tgbotapi.User, telegramID int64, role, actionType string) (string, db.Player, error) {
+	actor, err := b.store.GetPlayerByTelegramID(ctx, from.ID)
+	if err != nil {
+		return "", db.Player{}, err
+	}
+	return b.store.ChangePlayerRole(ctx, db.RoleChange{
+		ActorID:          &actor.ID,
+		ActorRole:        actor.Role,
+		TargetTelegramID: telegramID,
+		NewRole:          role,
+		Source:           db.AdminSourceBot,
+		ActionType:       actionType,
+		RaiseOnly:        actionType == db.ActionCreateAdmin,
+	})
+}
+
+func roleError(err error, fallback string) error {
+	switch {
+	case errors.Is(err, db.ErrInvalidRole):
+		return errors.New("Неизвестная роль. Доступны: player, moderator, admin, super_admin.")
+	case errors.Is(err, db.ErrRoleForbidden):
+		return errors.New("Недостаточно прав для такой смены роли: администраторы не могут менять роли супер-администраторов и назначать их.")
+	case errors.Is(err, db.ErrLastSuperAdmin):
+		return errors.New("Нельзя снять роль с последнего супер-администратора.")
+	case errors.Is(err, db.ErrOwnRoleChange):
+		return errors.New("Нельзя менять собственную роль.")
+	case errors.Is(err, db.ErrRoleNotRaised):
+		return errors.New("Игрок уже администратор или выше.")
+	case errors.Is(err, db.ErrPlayerNotFound):
+		return errors.New("Игрок не найден.")
+	default:
+		return errors.New(fallback)
+	}
+}